@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/keyring"
+	"github.com/RookieJoel/GoAPI-essential/shared/loginlimit"
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
+)
+
+// settings are read from flags, the environment and .env, see shared/config.
+// The database credentials have no default, put them in .env (DB_USER=myuser, DB_PASSWORD=mypassword)
+var settings = append(append(append([]config.Setting{
+	{Name: "PORT", Type: config.Int, Default: "8080", Min: 1, Max: 65535, Description: "port the server listens on"},
+	{Name: "SHUTDOWN_TIMEOUT", Type: config.Duration, Default: "10s", Description: "how long requests in flight may finish after SIGTERM"},
+	{Name: "SHUTDOWN_DELAY", Type: config.Duration, Description: "how long /readyz fails before the server stops accepting connections on SIGTERM"},
+	{Name: "JWT_ALG", Default: "RS256", Options: []string{keyring.RS256, keyring.EdDSA}, Description: "login token signing algorithm: RS256 or EdDSA"},
+	{Name: "JWT_KEY_DIR", Description: "directory keeping the signing keys, empty keeps them in memory only"},
+	{Name: "JWT_ROTATE_EVERY", Type: config.Duration, Default: "24h", Description: "how long a signing key is used before it is replaced"},
+	{Name: "JWT_KEY_OVERLAP", Type: config.Duration, Default: "72h", Description: "how long a replaced key still verifies tokens, at least the login lifetime (72h)"},
+	{Name: "ADMIN_EMAILS", Description: "comma separated emails of the users allowed on the /admin routes"},
+}, config.Postgres...), loginlimit.Settings...), reqlog.Settings...)
+
+// checkKeyOverlap: a replaced key must verify tokens for a whole login, or logins die early after a rotation
+func checkKeyOverlap(cfg *config.Config) error {
+	if cfg.Duration("JWT_KEY_OVERLAP") < tokenTTL {
+		return fmt.Errorf("JWT_KEY_OVERLAP must be at least %gh, the lifetime of a login", tokenTTL.Hours())
+	}
+	return nil
+}
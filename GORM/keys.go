@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/keyring"
+)
+
+// tokenTTL is how long a login stays valid, the cookie expires at the same time
+const tokenTTL = 72 * time.Hour
+
+// signingKeys signs the login tokens and verifies them in authMiddleware
+var signingKeys *keyring.Ring
+
+// newKeyRing reads the signing setup: JWT_ALG (RS256 or EdDSA), JWT_KEY_DIR (empty keeps keys
+// in memory only), JWT_ROTATE_EVERY and JWT_KEY_OVERLAP (checked to be at least tokenTTL)
+func newKeyRing(cfg *config.Config) (*keyring.Ring, error) {
+	return keyring.New(keyring.Config{
+		Alg:         cfg.Get("JWT_ALG"),
+		Dir:         cfg.Get("JWT_KEY_DIR"),
+		RotateEvery: cfg.Duration("JWT_ROTATE_EVERY"),
+		Overlap:     cfg.Duration("JWT_KEY_OVERLAP"),
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/loginlimit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// loginLimiter counts login attempts per client IP and per email, see shared/loginlimit
+var loginLimiter *loginlimit.Limiter
+
+// tooManyAttempts answers a refused login with 429 and Retry-After in whole seconds
+func tooManyAttempts(c *fiber.Ctx, d loginlimit.Decision) error {
+	retryAfter := int(math.Ceil(d.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Too many login attempts",
+		"reason":      d.Reason, // ip_rate_limited, account_rate_limited or account_locked
+		"retry_after": retryAfter,
+	})
+}
+
+// adminEmails are the emails listed in ADMIN_EMAILS, in lower case
+func adminEmails(cfg *config.Config) map[string]bool {
+	admins := map[string]bool{}
+	for _, email := range strings.Split(cfg.Get("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+	return admins
+}
+
+// isAdminEmail reports whether email is one of admins, however it is spelled.
+// /users/register refuses these: whoever registered an admin address first would get the /admin routes.
+func isAdminEmail(admins map[string]bool, email string) bool {
+	return admins[strings.ToLower(strings.TrimSpace(email))]
+}
+
+// adminOnly lets through the users whose email is listed in ADMIN_EMAILS.
+// It runs after authMiddleware, which leaves the token claims in c.Locals("claims").
+func adminOnly(admins map[string]bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, _ := c.Locals("claims").(*jwt.MapClaims)
+		if claims != nil {
+			if email, _ := (*claims)["email"].(string); admins[strings.ToLower(email)] {
+				return c.Next()
+			}
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Forbidden",
+		})
+	}
+}
+
+// listLockouts handles GET /admin/lockouts
+func listLockouts(c *fiber.Ctx) error {
+	return c.JSON(loginLimiter.Records())
+}
+
+// clearLockout handles DELETE /admin/lockouts/:kind/:id, kind is ip or account (the email)
+func clearLockout(c *fiber.Ctx) error {
+	kind := c.Params("kind")
+	if kind != loginlimit.KindIP && kind != loginlimit.KindAccount {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "kind must be ip or account",
+		})
+	}
+	if !loginLimiter.Clear(kind, c.Params("id")) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Nothing recorded for this " + kind,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"message": "Lockout cleared",
+	})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// slogLogger sends GORM's logs to slog, so queries come out as JSON lines with the
+// request ID of the context they ran with (db.WithContext(c.UserContext())).
+// Which ones are written is decided by LOG_LEVEL, GORM's own log level is ignored.
+type slogLogger struct{}
+
+func (l slogLogger) LogMode(logger.LogLevel) logger.Interface { return l }
+
+func (slogLogger) Info(ctx context.Context, msg string, args ...any) {
+	slog.InfoContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (slogLogger) Warn(ctx context.Context, msg string, args ...any) {
+	slog.WarnContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (slogLogger) Error(ctx context.Context, msg string, args ...any) {
+	slog.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+// Trace is called after every query
+func (slogLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil // the handler answers 404, not a failed query
+	}
+	sql, rows := fc()
+	reqlog.Query(ctx, sql, time.Since(begin), rows, err)
+}
@@ -1,39 +1,32 @@
 package main 
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
-	"os"
+	"log/slog"
+	"fmt"
 	"time"
 	"github.com/golang-jwt/jwt/v4"
-)
-
-// Database connection details
-const ( 
-	host     = "localhost"
-	port	 = 5433
-	user     = "myuser"
-	password = "mypassword"
-	dbname   = "mydatabase"
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/graceful"
+	"github.com/RookieJoel/GoAPI-essential/shared/health"
+	"github.com/RookieJoel/GoAPI-essential/shared/loginlimit"
+	"github.com/RookieJoel/GoAPI-essential/shared/metrics"
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
 )
 
 func authMiddleware(c *fiber.Ctx) error {
 	// Middleware to check for JWT token in cookies
 	cookie  := c.Cookies("jwt_token") // Get the JWT token from cookies with the name "jwt_token"
-	//for tsting only 
-	jwtSecret := []byte("secret_key") // Secret key for signing the JWT token
 
-	//check if the token is valid 
-	token , err:= jwt.ParseWithClaims(cookie, &jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil // Return the secret key for validation
-	})
+	//check if the token is valid, the public key is looked up by the kid in the token header
+	token , err:= jwt.ParseWithClaims(cookie, &jwt.MapClaims{}, signingKeys.Keyfunc)
 
 	if err != nil {
-		log.Println("Error parsing JWT token:", err)
+		slog.InfoContext(c.UserContext(), "rejected token", "error", err)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Unauthorized",
 		})
@@ -41,9 +34,9 @@ func authMiddleware(c *fiber.Ctx) error {
 
 	// Check if the token is valid and extract claims
 	if claims, ok := token.Claims.(*jwt.MapClaims); ok && token.Valid {
-		log.Println("JWT token is valid. Claims:", claims)
+		c.Locals("claims", claims) // for adminOnly and the request log
 	} else {
-		log.Println("Invalid JWT token")
+		slog.InfoContext(c.UserContext(), "rejected token", "error", "invalid claims")
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Unauthorized",
 		})
@@ -52,30 +45,54 @@ func authMiddleware(c *fiber.Ctx) error {
 	return c.Next() // Proceed to the next handler
 }
 
+// tokenSubject names the caller in the request log by the user ID of the token, not the email
+func tokenSubject(c *fiber.Ctx) string {
+	claims, _ := c.Locals("claims").(*jwt.MapClaims)
+	if claims == nil {
+		return ""
+	}
+	if id, ok := (*claims)["id"].(float64); ok {
+		return fmt.Sprintf("user:%d", int64(id))
+	}
+	return ""
+}
+
 func main() {
+	// stops here with a list of every invalid or missing setting, see config.go
+	cfg := config.MustLoad(settings, ".env", checkKeyOverlap, loginlimit.CheckSettings)
+	// JSON lines on stdout (LOG_FORMAT, LOG_LEVEL), log.Println included
+	reqLogger := reqlog.Setup(cfg)
 
 	// Connection string
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
-
-	// New logger for detailed SQL logging
-  newLogger := logger.New(
-    log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
-    logger.Config{
-      SlowThreshold: time.Second, // Slow SQL threshold
-      LogLevel:      logger.Info, // Log level , there are 4 levels: Silent, Error, Warn, Info
-      Colorful:      true,        // Enable color
-    },
-  )
-	// Open a connection
+	dsn := cfg.PostgresDSN()
+
+	// Open a connection, the queries are logged as JSON lines with the request ID (LOG_LEVEL=debug shows them all)
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: newLogger, // Use the new logger
+		Logger: slogLogger{}, // see logger.go
+		DisableAutomaticPing: true, // waited for below
 	})
+	if err != nil {
+		log.Fatalf("Error opening the database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("Error getting the connection pool: %v", err)
+	}
 
+	// the database may still be starting (docker compose up), the migration below needs it
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration("DB_CONNECT_TIMEOUT"))
+	err = health.WaitFor(ctx, health.Ping(sqlDB), time.Second)
+	cancel()
 	if err != nil {
-		panic("failed to connect to database: " + err.Error()) //panic use to stop the program if the connection fails
+		log.Fatalf("Database not reachable after %s: %v", cfg.Get("DB_CONNECT_TIMEOUT"), err)
 	}
 	log.Println("Successfully connected to the database!")
+
+	// query durations per operation and table, served on /metrics with the request metrics
+	stats := metrics.New()
+	if err := db.Use(newQueryMetrics(stats.Registerer())); err != nil {
+		log.Fatalf("Error adding the metrics plugin: %v", err)
+	}
 	
 	// Migrate the schema
 	err = db.AutoMigrate(&Book{}, &User{}) // Automatically create the table based on the Book struct
@@ -139,10 +156,46 @@ func main() {
 	// 	log.Printf("Retrieved All Books: %+v\n", books)
 	// }
 
+	// ========== Signing Keys ==========
+	signingKeys, err = newKeyRing(cfg)
+	if err != nil {
+		log.Fatalf("Error configuring signing keys: %v", err)
+	}
+	stopRotation := make(chan struct{})
+	go signingKeys.Run(stopRotation) // rotate in the background until the server shuts down
+
+	// login attempts per client IP and per email, with lockouts after repeated failures.
+	// nil keeps the state in memory; a shared loginlimit.Store would make the limits hold across instances.
+	loginLimiter = loginlimit.FromConfig(cfg, nil)
+
 	// ========== Fiber Setup ==========
 	app := fiber.New()
 
+	// one log line per request, tagged with its X-Request-ID like the queries it ran
+	app.Use(reqlog.Middleware(reqLogger, tokenSubject))
+
+	// requests per route, latencies and the connection pool (go_sql_*), scraped by Prometheus
+	app.Use(stats.Middleware())
+	app.Get("/metrics", stats.Handler())
+
+	// probes: /healthz while the process runs, /readyz while the database answers too
+	checks := health.New(health.DefaultTimeout)
+	checks.Register("postgres", health.Ping(sqlDB))
+	app.Get("/healthz", checks.Live)
+	app.Get("/readyz", checks.Ready)
+
+	// once the requests in flight are done: stop rotating keys and close the connection pool
+	stats.RegisterDB(cfg.Get("DB_NAME"), sqlDB)
+	app.Hooks().OnShutdown(func() error {
+		close(stopRotation)
+		return sqlDB.Close()
+	})
+
+	//public signing keys so other services can verify our tokens
+	app.Get("/.well-known/jwks.json", signingKeys.Handler)
+
 	// ========== User Routes ==========
+	admins := adminEmails(cfg) // may use /admin, so they can't be registered by just anyone
 	app.Post("/users/register" , func (c *fiber.Ctx) error {
 		user := new(User)
 		if err := c.BodyParser(user); err != nil {
@@ -150,7 +203,12 @@ func main() {
 				"error": "Invalid request body",
 			})
 		}
-		if err := createUSer(db, user); err != nil {
+		if isAdminEmail(admins, user.Email) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This email is listed in ADMIN_EMAILS, register it before listing it",
+			})
+		}
+		if err := createUSer(db.WithContext(c.UserContext()), user); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": err.Error(),
 			})
@@ -168,18 +226,24 @@ func main() {
 				"error": "Invalid request body",
 			})
 		}
-		token, err := loginUser(db, user)
+		// throttle before checking the password, a refused attempt doesn't even cost a bcrypt hash
+		if d := loginLimiter.Allow(c.IP(), user.Email); !d.Allowed {
+			return tooManyAttempts(c, d)
+		}
+		token, err := loginUser(db.WithContext(c.UserContext()), user)
 		if err != nil {
+			loginLimiter.Failure(user.Email) // enough failures in a row lock the account
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid email or password",
 			})
 		}
+		loginLimiter.Success(user.Email)
 
 		//set cookie with JWT token
 		c.Cookie(&fiber.Cookie{
 			Name:     "jwt_token",
 			Value:    token,
-			Expires:  time.Now().Add(72 * time.Hour), // Set expiration to 72 hours
+			Expires:  time.Now().Add(tokenTTL), // Set expiration to 72 hours
 			HTTPOnly: true, // Prevent JavaScript access to the cookie
 		})
 
@@ -193,7 +257,7 @@ func main() {
 	// ========== Book Routes ==========
 	//get all books
 	app.Get("/books", func (c *fiber.Ctx) error {
-		books , err := getAllBooks(db)
+		books , err := getAllBooks(db.WithContext(c.UserContext()))
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": err.Error(),
@@ -210,7 +274,7 @@ func main() {
 				"error": "Invalid book ID",
 			})
 		}
-		if book , err := getBookById(db, uint(bid)); err != nil {
+		if book , err := getBookById(db.WithContext(c.UserContext()), uint(bid)); err != nil {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Book not found",
 			})
@@ -227,7 +291,7 @@ func main() {
 				"error": "Invalid request body",
 			})
 		}
-		if err := createBook(db, &newBook); err != nil {
+		if err := createBook(db.WithContext(c.UserContext()), &newBook); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": err.Error(),
 			})
@@ -253,7 +317,7 @@ func main() {
 			})
 		}
 		updatedBook.ID = uint(bid) // Set the ID for the book to update
-		if err := updateBook(db, &updatedBook); err != nil {
+		if err := updateBook(db.WithContext(c.UserContext()), &updatedBook); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": err.Error(),
 			})
@@ -272,7 +336,7 @@ func main() {
 				"error": "Invalid book ID",
 			})
 		}
-		if err := deleteBook(db, uint(bid)); err != nil {
+		if err := deleteBook(db.WithContext(c.UserContext()), uint(bid)); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": err.Error(),
 			})
@@ -282,6 +346,17 @@ func main() {
 		})
 	})
 
-	app.Listen(":8080")
+	// ========== Admin Routes ==========
+	// who is throttled or locked out of logging in, and lifting it early
+	admin := app.Group("/admin", adminOnly(admins))
+	admin.Get("/lockouts", listLockouts)
+	admin.Delete("/lockouts/:kind/:id", clearLockout)
+
+	// on SIGTERM /readyz fails for SHUTDOWN_DELAY before the requests are drained
+	err = graceful.ListenAndServe(app, ":"+cfg.Get("PORT"), cfg.Duration("SHUTDOWN_TIMEOUT"),
+		graceful.OnStop(checks.Drain), graceful.Delay(cfg.Duration("SHUTDOWN_DELAY")))
+	if err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
 
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// queryMetrics is a GORM plugin that times every query into gorm_query_duration_seconds.
+// It wraps GORM's own create, query, update, delete, row and raw callbacks.
+type queryMetrics struct {
+	duration *prometheus.HistogramVec
+}
+
+const queryStartKey = "metrics:start"
+
+func newQueryMetrics(reg prometheus.Registerer) *queryMetrics {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gorm_query_duration_seconds",
+		Help:    "Time GORM spent on a query, by operation and table.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to 4s
+	}, []string{"operation", "table"})
+	reg.MustRegister(duration)
+	return &queryMetrics{duration: duration}
+}
+
+func (*queryMetrics) Name() string {
+	return "metrics"
+}
+
+// Initialize is called by db.Use
+func (p *queryMetrics) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("metrics:before_create", p.start),
+		cb.Create().After("gorm:create").Register("metrics:after_create", p.observe("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", p.start),
+		cb.Query().After("gorm:query").Register("metrics:after_query", p.observe("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", p.start),
+		cb.Update().After("gorm:update").Register("metrics:after_update", p.observe("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", p.start),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", p.observe("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", p.start),
+		cb.Row().After("gorm:row").Register("metrics:after_row", p.observe("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", p.start),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", p.observe("raw")),
+	)
+}
+
+func (*queryMetrics) start(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func (p *queryMetrics) observe(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		start, ok := db.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table // "" for raw SQL
+		p.duration.WithLabelValues(operation, table).Observe(time.Since(start.(time.Time)).Seconds())
+	}
+}
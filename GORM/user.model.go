@@ -4,7 +4,6 @@ import (
 	"gorm.io/gorm"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/golang-jwt/jwt/v4"
-	"time"
 )
 
 type User struct { 
@@ -37,16 +36,14 @@ func loginUser(db *gorm.DB, user *User) (string, error) {
 		return "", err // Passwords do not match
 	}
 	//pass => return jwt
-	// Create a new JWT token
-	token := jwt.New(jwt.SigningMethodHS256)
 	// Set claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["id"] = selectedUser.ID // Set user ID in claims
 	claims["email"] = selectedUser.Email // Set user email in claims
-	claims["exp"] = jwt.TimeFunc().Add(time.Hour * 72).Unix() // Set expiration time (72 hours)
+	claims["exp"] = jwt.TimeFunc().Add(tokenTTL).Unix() // Set expiration time (72 hours)
 
-	// Sign the token with a secret key
-	if tokenString, err := token.SignedString([]byte("secret_key")); err != nil {
+	// Sign the token with the active key, its kid goes into the header
+	if tokenString, err := signingKeys.Sign(claims); err != nil {
 		return "", err
 	}else { 
 		return tokenString, nil 
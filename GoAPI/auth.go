@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/keyring"
+	"github.com/gofiber/fiber/v2"
+	jwtware "github.com/gofiber/jwt/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// refreshToken is what we remember about an issued refresh token.
+// Only its hash is kept, the token itself is only ever seen by the client.
+type refreshToken struct {
+	userID  int
+	family  string // all tokens rotated from the same login share a family
+	expires time.Time
+	used    bool // set once the token was exchanged, using it again means it was stolen
+}
+
+// tokenFamily tracks one login session across refresh token rotations
+type tokenFamily struct {
+	revoked    bool
+	accessJTIs map[string]time.Time // access tokens issued in this family and when they expire
+}
+
+// tokenService issues short-lived access tokens and rotating refresh tokens,
+// and keeps the jti denylist consulted by the JWT middleware.
+type tokenService struct {
+	users      UserStore // refresh looks the user up again, so role changes and deletions take effect
+	keys       *keyring.Ring
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	mu       sync.Mutex
+	refresh  map[string]*refreshToken // keyed by the SHA-256 of the token
+	families map[string]*tokenFamily
+	denylist map[string]time.Time // revoked access token jti -> when it expires anyway
+}
+
+func newTokenService(users UserStore, keys *keyring.Ring, accessTTL, refreshTTL time.Duration) *tokenService {
+	return &tokenService{
+		users:      users,
+		keys:       keys,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		refresh:    map[string]*refreshToken{},
+		families:   map[string]*tokenFamily{},
+		denylist:   map[string]time.Time{},
+	}
+}
+
+// newTokenServiceFromConfig reads ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL
+func newTokenServiceFromConfig(cfg *appConfig, users UserStore, keys *keyring.Ring) *tokenService {
+	return newTokenService(users, keys, cfg.Duration("ACCESS_TOKEN_TTL"), cfg.Duration("REFRESH_TOKEN_TTL"))
+}
+
+// newKeyRingFromConfig reads JWT_ALG, JWT_KEY_DIR, JWT_ROTATE_EVERY and JWT_KEY_OVERLAP.
+// loadConfig already checked that the overlap covers a whole access token lifetime.
+func newKeyRingFromConfig(cfg *appConfig) (*keyring.Ring, error) {
+	return keyring.New(keyring.Config{
+		Alg:         cfg.Get("JWT_ALG"),
+		Dir:         cfg.Get("JWT_KEY_DIR"),
+		RotateEvery: cfg.Duration("JWT_ROTATE_EVERY"),
+		Overlap:     cfg.Duration("JWT_KEY_OVERLAP"),
+	})
+}
+
+// newJWTMiddleware validates access tokens with the key ring.
+// jwtware only knows the keys it was created with, so it is rebuilt after every rotation
+// and whenever a retired key is dropped at the end of its overlap window.
+func newJWTMiddleware(keys *keyring.Ring, success fiber.Handler) fiber.Handler {
+	var current atomic.Pointer[fiber.Handler]
+	build := func() {
+		handler := jwtware.New(jwtware.Config{
+			SigningMethod:  keys.Alg(),
+			SigningKeys:    keys.VerificationKeys(), //public keys by kid, including the ones still in their overlap window
+			SuccessHandler: success,
+			ErrorHandler:   jwtError, //missing or invalid tokens are reported as problem+json too
+		})
+		current.Store(&handler)
+	}
+	build()
+	keys.OnRotate(build)
+
+	return func(c *fiber.Ctx) error {
+		// jwtware reports a missing token with the same kind of error as a bad one, so tell them apart first
+		if !hasBearerToken(c) {
+			return missingToken(c)
+		}
+		return (*current.Load())(c)
+	}
+}
+
+// hasBearerToken reports whether the Authorization header carries a Bearer token, valid or not
+func hasBearerToken(c *fiber.Ctx) bool {
+	scheme, token, _ := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	return strings.EqualFold(scheme, "Bearer") && strings.TrimSpace(token) != ""
+}
+
+// tokenPair is what login and refresh send back to the client
+type tokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // access token lifetime in seconds
+}
+
+// issue starts a new token family for user
+func (s *tokenService) issue(user User) (tokenPair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked()
+
+	family := randomToken(16)
+	s.families[family] = &tokenFamily{accessJTIs: map[string]time.Time{}}
+	return s.issueLocked(user, family)
+}
+
+// rotate exchanges a refresh token for a new pair. Presenting a token that was already
+// exchanged means it leaked, so the whole family (every token of that session) is revoked.
+func (s *tokenService) rotate(presented string) (tokenPair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked()
+
+	rt, ok := s.refresh[hashToken(presented)]
+	if !ok {
+		return tokenPair{}, errInvalidRefreshToken
+	}
+	family := s.families[rt.family]
+	if rt.used {
+		s.revokeFamilyLocked(rt.family)
+		return tokenPair{}, errInvalidRefreshToken
+	}
+	if family == nil || family.revoked || time.Now().After(rt.expires) {
+		return tokenPair{}, errInvalidRefreshToken
+	}
+
+	user, err := s.users.Get(rt.userID)
+	if err != nil {
+		s.revokeFamilyLocked(rt.family) // the account is gone, so is the session
+		return tokenPair{}, errInvalidRefreshToken
+	}
+
+	rt.used = true
+	return s.issueLocked(user, rt.family)
+}
+
+// revoke denylists one access token and ends its family so its refresh token stops working too
+func (s *tokenService) revoke(jti, family string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.denylist[jti] = expires
+	s.revokeFamilyLocked(family)
+}
+
+// isRevoked reports whether an access token was revoked, directly or through its family.
+// A family we don't know was started by an earlier run of the server: with JWT_KEY_DIR its tokens
+// still verify after a restart, and they stay valid until they expire (revocations are kept in memory only).
+func (s *tokenService) isRevoked(jti, family string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, denied := s.denylist[jti]; denied {
+		return true
+	}
+	f, ok := s.families[family]
+	return ok && f.revoked
+}
+
+// issueLocked signs a new access token and stores a new refresh token. Callers must hold s.mu.
+func (s *tokenService) issueLocked(user User, family string) (tokenPair, error) {
+	now := time.Now()
+	jti := randomToken(16)
+	expires := now.Add(s.accessTTL)
+
+	// Generate JWT token, signed with the active key of the ring
+	tokenString, err := s.keys.Sign(jwt.MapClaims{
+		"username": user.Username,
+		"uid":      user.ID,
+		"role":     user.Role,
+		"jti":      jti,
+		"fam":      family, // lets logout and reuse detection find the session
+		"iat":      now.Unix(),
+		"exp":      expires.Unix(),
+	})
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	refresh := randomToken(32)
+	s.refresh[hashToken(refresh)] = &refreshToken{
+		userID:  user.ID,
+		family:  family,
+		expires: now.Add(s.refreshTTL),
+	}
+	s.families[family].accessJTIs[jti] = expires
+
+	return tokenPair{
+		AccessToken:  tokenString,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTTL.Seconds()),
+	}, nil
+}
+
+// revokeFamilyLocked revokes every refresh and access token of a family. Callers must hold s.mu.
+func (s *tokenService) revokeFamilyLocked(family string) {
+	f, ok := s.families[family]
+	if !ok {
+		return
+	}
+	f.revoked = true
+	for jti, expires := range f.accessJTIs {
+		s.denylist[jti] = expires
+	}
+}
+
+// sweepLocked forgets tokens that expired on their own, so the maps don't grow forever.
+// Callers must hold s.mu.
+func (s *tokenService) sweepLocked() {
+	now := time.Now()
+	for jti, expires := range s.denylist {
+		if now.After(expires) {
+			delete(s.denylist, jti)
+		}
+	}
+	live := map[string]bool{}
+	for hash, rt := range s.refresh {
+		if now.After(rt.expires) {
+			delete(s.refresh, hash)
+			continue
+		}
+		live[rt.family] = true
+	}
+	for id, f := range s.families {
+		for jti, expires := range f.accessJTIs {
+			if now.After(expires) {
+				delete(f.accessJTIs, jti)
+			}
+		}
+		if !live[id] && len(f.accessJTIs) == 0 {
+			delete(s.families, id)
+		}
+	}
+}
+
+// rejectRevoked runs after jwtware accepted the token and turns away denylisted tokens
+func (s *tokenService) rejectRevoked(c *fiber.Ctx) error {
+	claims := tokenClaims(c)
+	jti, _ := claims["jti"].(string)
+	family, _ := claims["fam"].(string)
+	if jti == "" || s.isRevoked(jti, family) {
+		return probTokenRevoked.with("Log in again to get a new token")
+	}
+	return c.Next()
+}
+
+// refreshRequest is the body of POST /token/refresh
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshHandler handles POST /token/refresh with {"refresh_token": "..."}
+func (s *tokenService) refreshHandler(c *fiber.Ctx) error {
+	var body refreshRequest
+	if err := c.BodyParser(&body); err != nil || body.RefreshToken == "" {
+		return probMalformedRequest.with("refresh_token is required")
+	}
+
+	pair, err := s.rotate(body.RefreshToken)
+	if err != nil {
+		return err //errInvalidRefreshToken is a 401 problem, anything else a 500
+	}
+	return c.JSON(pair)
+}
+
+// logoutHandler handles POST /logout, it revokes the caller's access token and its refresh token
+func (s *tokenService) logoutHandler(c *fiber.Ctx) error {
+	claims := tokenClaims(c)
+	jti, _ := claims["jti"].(string)
+	family, _ := claims["fam"].(string)
+	exp, _ := claims["exp"].(float64)
+
+	s.revoke(jti, family, time.Unix(int64(exp), 0))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// tokenClaims returns the claims of the token jwtware stored in the context
+func tokenClaims(c *fiber.Ctx) jwt.MapClaims {
+	token, ok := c.Locals("user").(*jwt.Token)
+	if !ok {
+		return jwt.MapClaims{}
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return claims
+}
+
+// tokenSubject names the caller in the request log
+func tokenSubject(c *fiber.Ctx) string {
+	username, _ := tokenClaims(c)["username"].(string)
+	return username
+}
+
+func randomToken(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
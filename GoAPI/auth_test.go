@@ -0,0 +1,281 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/keyring"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestBookLifecycle walks the way a client uses the API: log in, then create, read,
+// replace, patch and delete a book with the token, following the ETags.
+func TestBookLifecycle(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	c := newClient(t, app)
+
+	login := c.login("admin", "password")
+	if login.TokenType != "Bearer" || login.RefreshToken == "" || login.ExpiresIn <= 0 {
+		t.Fatalf("unexpected login response %+v", login)
+	}
+
+	var created Book
+	resp := c.post("/books", Book{Title: "Concurrency in Go", Author: "Katherine Cox-Buday", ISBN: "978-1-4919-4119-5"}).
+		expect(t, fiber.StatusCreated)
+	resp.decode(t, &created)
+	if created.ID == "" || created.Version != 1 || created.ISBN != "9781491941195" {
+		t.Fatalf("created %+v, want an ID, version 1 and the ISBN-13 without hyphens", created)
+	}
+	etag := resp.header.Get(fiber.HeaderETag)
+	path := "/books/" + string(created.ID)
+
+	var got Book
+	c.get(path).expect(t, fiber.StatusOK).decode(t, &got)
+	if got.Title != created.Title {
+		t.Fatalf("GET %s = %+v, want %+v", path, got, created)
+	}
+	c.do(request{method: fiber.MethodGet, path: path, header: map[string]string{fiber.HeaderIfNoneMatch: etag}}).
+		expect(t, fiber.StatusNotModified)
+	c.get("/books/isbn/1491941197").expect(t, fiber.StatusOK) // the same book by its ISBN-10
+
+	var updated Book
+	resp = c.do(request{
+		method: fiber.MethodPut, path: path,
+		body:   Book{Title: "Concurrency in Go", Author: "Katherine Cox-Buday", Year: 2017},
+		header: map[string]string{fiber.HeaderIfMatch: etag},
+	}).expect(t, fiber.StatusOK)
+	resp.decode(t, &updated)
+	if updated.Year != 2017 || updated.ISBN != "" || updated.Version != 2 {
+		t.Fatalf("PUT replaced the book with %+v", updated)
+	}
+	// the old ETag is stale now
+	c.do(request{method: fiber.MethodDelete, path: path, header: map[string]string{fiber.HeaderIfMatch: etag}}).
+		expectProblem(t, probPreconditionFailed)
+	etag = resp.header.Get(fiber.HeaderETag)
+
+	var patched Book
+	c.do(request{
+		method: fiber.MethodPatch, path: path, body: `{"pages": 304}`, contentType: mimeMergePatch,
+		header: map[string]string{fiber.HeaderIfMatch: etag},
+	}).expect(t, fiber.StatusOK).decode(t, &patched)
+	if patched.Pages != 304 || patched.Year != 2017 || patched.Version != 3 {
+		t.Fatalf("PATCH gave %+v", patched)
+	}
+
+	resp = c.do(request{method: fiber.MethodDelete, path: path}).expect(t, fiber.StatusOK)
+	if string(resp.body) != "Book deleted successfully" {
+		t.Errorf("DELETE answered %q", resp.body)
+	}
+	c.get(path).expectProblem(t, probBookNotFound)
+}
+
+func TestRegisterAndRoles(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	reader := newClient(t, app)
+	reader.post("/register", credentials{Username: "alice", Password: "correct horse"}).expect(t, fiber.StatusCreated)
+	reader.post("/register", credentials{Username: "alice", Password: "battery staple"}).expectProblem(t, probUsernameTaken)
+	reader.login("alice", "correct horse")
+
+	// readers may read, but not write
+	reader.get("/books").expect(t, fiber.StatusOK)
+	p := reader.post("/books", Book{Title: "Mine", Author: "Alice"}).expectProblem(t, probForbidden)
+	if p.Reason != denyInsufficientRole {
+		t.Errorf("reason %q, want %q", p.Reason, denyInsufficientRole)
+	}
+	reader.get("/users").expectProblem(t, probForbidden)
+	reader.get("/admin/config").expectProblem(t, probForbidden)
+
+	// editors may write books, but not manage users
+	editor := loggedIn(t, app, roleEditor)
+	editor.post("/books", Book{Title: "Theirs", Author: "Bob"}).expect(t, fiber.StatusCreated)
+	editor.get("/users").expectProblem(t, probForbidden)
+
+	loggedIn(t, app, roleAdmin).get("/users").expect(t, fiber.StatusOK)
+}
+
+func TestLoginFailures(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	c := newClient(t, app)
+	c.post("/login", credentials{Username: "admin", Password: "wrong"}).expectProblem(t, probBadCredentials)
+	c.post("/login", credentials{Username: "nobody", Password: "password"}).expectProblem(t, probBadCredentials)
+	c.do(request{method: fiber.MethodPost, path: "/login", body: "{", contentType: fiber.MIMEApplicationJSON}).
+		expectProblem(t, probMalformedRequest)
+}
+
+func TestLoginThrottled(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t, "-login-max-failures", "2")
+	c := newClient(t, app)
+	for range 2 {
+		c.post("/login", credentials{Username: "admin", Password: "wrong"}).expectProblem(t, probBadCredentials)
+	}
+	resp := c.post("/login", credentials{Username: "admin", Password: "password"})
+	resp.expectProblem(t, probTooManyAttempts)
+	if resp.header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Error("429 without Retry-After")
+	}
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	c := newClient(t, app)
+	login := c.login("admin", "password")
+
+	var pair tokenPair
+	c.post("/token/refresh", refreshRequest{RefreshToken: login.RefreshToken}).expect(t, fiber.StatusOK).decode(t, &pair)
+	if pair.AccessToken == "" || pair.RefreshToken == login.RefreshToken {
+		t.Fatalf("refresh gave %+v, want a new token pair", pair)
+	}
+	c.post("/token/refresh", refreshRequest{}).expectProblem(t, probMalformedRequest)
+
+	c.token = pair.AccessToken
+	c.get("/books").expect(t, fiber.StatusOK)
+	c.post("/logout", nil).expect(t, fiber.StatusNoContent)
+	c.get("/books").expectProblem(t, probTokenRevoked)
+	c.post("/token/refresh", refreshRequest{RefreshToken: pair.RefreshToken}).expectProblem(t, probInvalidRefreshToken)
+}
+
+// TestRefreshTokenReuse replays a refresh token that was already exchanged: it was stolen,
+// so every token of its login goes, including the ones the rightful client got meanwhile
+func TestRefreshTokenReuse(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	c := newClient(t, app)
+	login := c.login("admin", "password")
+
+	var pair tokenPair
+	c.post("/token/refresh", refreshRequest{RefreshToken: login.RefreshToken}).expect(t, fiber.StatusOK).decode(t, &pair)
+	c.post("/token/refresh", refreshRequest{RefreshToken: login.RefreshToken}).expectProblem(t, probInvalidRefreshToken)
+
+	c.token = pair.AccessToken
+	c.get("/books").expectProblem(t, probTokenRevoked)
+	c.post("/token/refresh", refreshRequest{RefreshToken: pair.RefreshToken}).expectProblem(t, probInvalidRefreshToken)
+}
+
+// TestTokenSurvivesRestart checks that an access token still works after a restart that keeps
+// the signing keys: the new process doesn't know its family, which doesn't make it revoked
+func TestTokenSurvivesRestart(t *testing.T) {
+	t.Parallel()
+	keyDir := t.TempDir()
+	before := newClient(t, newTestApp(t, "-jwt-key-dir", keyDir))
+	before.token = before.login("admin", "password").AccessToken
+
+	after := newClient(t, newTestApp(t, "-jwt-key-dir", keyDir))
+	after.token = before.token
+	after.get("/books").expect(t, fiber.StatusOK)
+}
+
+// TestJWTMiddleware sends every kind of bad Authorization header to a protected route,
+// with tokens signed by either algorithm
+func TestJWTMiddleware(t *testing.T) {
+	t.Parallel()
+	for _, alg := range []string{keyring.RS256, keyring.EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			t.Parallel()
+			testJWTMiddleware(t, "-jwt-alg", alg)
+		})
+	}
+}
+
+func testJWTMiddleware(t *testing.T, args ...string) {
+	app := newTestApp(t, args...)
+	token := newClient(t, app).login("admin", "password").AccessToken
+
+	// a token signed by another server, with keys of its own
+	foreign := newClient(t, newTestApp(t, args...)).login("admin", "password").AccessToken
+
+	// the same token with its signature changed
+	dot := strings.LastIndex(token, ".")
+	sig := []byte(token[dot+1:])
+	sig[len(sig)/2] ^= 1
+	tampered := token[:dot+1] + string(sig)
+
+	tests := []struct {
+		name   string
+		header string
+		want   problemType
+	}{
+		{"no header", "", probMissingToken},
+		{"basic auth", "Basic YWRtaW46cGFzc3dvcmQ=", probMissingToken},
+		{"no token", "Bearer ", probMissingToken},
+		{"only spaces", "Bearer    ", probMissingToken},
+		{"lower-case scheme", "bearer not-a-token", probInvalidToken},
+		{"not a JWT", "Bearer not-a-token", probInvalidToken},
+		{"tampered signature", "Bearer " + tampered, probInvalidToken},
+		{"foreign key", "Bearer " + foreign, probInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient(t, app)
+			resp := c.do(request{method: fiber.MethodGet, path: "/books", header: map[string]string{fiber.HeaderAuthorization: tt.header}})
+			resp.expectProblem(t, tt.want)
+			if got := resp.header.Get(fiber.HeaderWWWAuthenticate); got != "Bearer" {
+				t.Errorf("WWW-Authenticate %q, want Bearer", got)
+			}
+		})
+	}
+
+	// public routes don't need a token at all
+	for _, path := range []string{"/greet", "/healthz", "/.well-known/jwks.json", "/openapi.json"} {
+		newClient(t, app).get(path).expect(t, fiber.StatusOK)
+	}
+	valid := newClient(t, app)
+	valid.token = token
+	valid.get("/books").expect(t, fiber.StatusOK)
+}
+
+func TestJWTMiddlewareExpiredToken(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for a token to expire")
+	}
+	t.Parallel()
+	app := newTestApp(t, "-access-token-ttl", "1s")
+	c := newClient(t, app)
+	c.login("admin", "password")
+	c.get("/books").expect(t, fiber.StatusOK)
+
+	time.Sleep(2 * time.Second) // exp has a resolution of one second
+	p := c.get("/books").expectProblem(t, probInvalidToken)
+	if !strings.Contains(p.Detail, "expired") {
+		t.Errorf("detail %q doesn't say the token expired", p.Detail)
+	}
+}
+
+// TestConfigHidesSecrets checks that GET /admin/config shows a secret only as a keyed fingerprint
+func TestConfigHidesSecrets(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	var report configReport
+	loggedIn(t, app, roleAdmin).get("/admin/config").expect(t, fiber.StatusOK).decode(t, &report)
+	for _, entry := range report.Settings {
+		if entry.Name != "ADMIN_PASSWORD" {
+			continue
+		}
+		if !entry.Secret || entry.Value != "" || entry.Fingerprint != config.Fingerprint("password") {
+			t.Errorf("ADMIN_PASSWORD is shown as %+v", entry)
+		}
+		return
+	}
+	t.Error("ADMIN_PASSWORD is not listed")
+}
+
+// TestAdminPasswordRequired checks that the server doesn't start with a well-known admin password
+func TestAdminPasswordRequired(t *testing.T) {
+	t.Setenv("ADMIN_PASSWORD", "")
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadConfig(envFile, nil)
+	if err == nil || !strings.Contains(err.Error(), "ADMIN_PASSWORD") {
+		t.Errorf("loading without ADMIN_PASSWORD: %v, want it reported", err)
+	}
+}
@@ -1,97 +1,222 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+
 	"github.com/gofiber/fiber/v2" //import fiber
-	"strconv" //for converting string to int
 )
 
+// bookHandler groups the book routes together with the store they use.
+// The store is injected so the handlers never touch shared state directly.
+type bookHandler struct {
+	store  BookStore
+	ids    idAllocator  // knows which ID format the :id routes accept
+	search *searchIndex // full-text index that follows the store
+}
+
+func newBookHandler(store BookStore, ids idAllocator, search *searchIndex) *bookHandler {
+	return &bookHandler{store: store, ids: ids, search: search}
+}
+
+// getBooks supports filtering (?author= ?title= ?q=), sorting (?sort=title,-author)
+// and paging (?limit= ?offset= or the opaque ?cursor= from the next/prev links)
+func (h *bookHandler) getBooks(c *fiber.Ctx) error {
+	query, err := parseBookQuery(c)
+	if err != nil {
+		return probInvalidQuery.wrap(err) //returning 400 for bad query parameters
+	}
 
-func getBooks(c *fiber.Ctx) error {
-	return c.JSON(books) //returning books as JSON
+	books, total := query.apply(h.store.List())
+	page := bookPage{
+		Data: books,
+		Meta: pageMeta{
+			Total:  total,
+			Count:  len(books),
+			Limit:  query.limit,
+			Offset: query.offset,
+		},
+		Links: query.links(c, total),
+	}
+	if link := page.Links.linkHeader(); link != "" {
+		c.Set(fiber.HeaderLink, link) // same links for clients that prefer headers
+	}
+	return c.JSON(page) //returning the page of books as JSON
 }
 
-func getBookByID(c *fiber.Ctx) error {
+func (h *bookHandler) getBookByID(c *fiber.Ctx) error {
 	id := c.Params("id") //getting the id (string) from the URL
-	
-	// Convert the id to an integer
-	bookID, err := strconv.Atoi(id) //converting string to int
+
+	// Check the id matches the configured ID type
+	bookID, err := h.ids.Parse(id)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error()) //returning 400 if conversion fails
+		return err //errInvalidID, the error handler answers 400 if the id is malformed
 	}
 
-	// Loop through the books to find the one with the matching ID
-	// _ = index and book variables
-	for _, book := range books {
-		if book.ID == bookID {
-			return c.JSON(book) //returning the book as JSON if found
-		}
+	book, err := h.store.Get(bookID)
+	if err != nil {
+		return err //ErrBookNotFound becomes a 404 problem in the error handler
+	}
+
+	c.Set(fiber.HeaderETag, bookETag(book))
+	if notModified(c, book) {
+		return c.SendStatus(fiber.StatusNotModified) //returning 304, the client's copy is still current
+	}
+	return c.JSON(book) //returning the book as JSON if found
+}
+
+// getBookByISBN looks a book up by ISBN-10 or ISBN-13, with or without hyphens
+func (h *bookHandler) getBookByISBN(c *fiber.Ctx) error {
+	isbn, err := normalizeISBN(c.Params("isbn"))
+	if err != nil {
+		return err //errInvalidISBN, the error handler answers 400
+	}
+
+	book, err := h.store.GetByISBN(isbn)
+	if err != nil {
+		return err //returning 404 if no book has this ISBN
+	}
+
+	c.Set(fiber.HeaderETag, bookETag(book))
+	if notModified(c, book) {
+		return c.SendStatus(fiber.StatusNotModified)
 	}
-	
-	return c.Status(fiber.StatusNotFound).SendString("Book not found") //returning 404 if book not found
+	return c.JSON(book)
 }
 
-func createBook(c *fiber.Ctx) error {
-	
+func (h *bookHandler) createBook(c *fiber.Ctx) error {
+
 	newBook := new(Book) // Create a new Book instance to hold the incoming data, this is like getting pointer to a Book struct
 
-	// Parse the JSON body into the newBook variable
-	if err := c.BodyParser(newBook); err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error()) //returning 400 if parsing fails
+	// Parse the JSON body into the newBook variable and check it against the rules on Book
+	if err := parseBook(c, newBook); err != nil {
+		return err //returning 400 if parsing fails, 422 listing every invalid field
 	}
-	// Assign an ID to the new book (incremental)
-	newBook.ID = len(books) + 1
 
-	// Append the new book to the books slice
-	books = append(books, *newBook) //append only accepts a value, so we need to send only the value of newBook, not the pointer
+	// The store assigns the ID and saves the book
+	created, err := h.store.Create(*newBook) //the store accepts a value, so we send only the value of newBook, not the pointer
+	if err != nil {
+		return err
+	}
 
-	return c.Status(fiber.StatusCreated).JSON(newBook) //returning 201 and the new book as JSON
+	c.Set(fiber.HeaderETag, bookETag(created))
+	return c.Status(fiber.StatusCreated).JSON(created) //returning 201 and the new book as JSON
 }
 
-func updateBook(c *fiber.Ctx) error {
+func (h *bookHandler) updateBook(c *fiber.Ctx) error {
 	id := c.Params("id") //getting the id (string) from the URL
-	
-	// Convert the id to an integer
-	bookID, err := strconv.Atoi(id) //converting string to int
+
+	// Check the id matches the configured ID type
+	bookID, err := h.ids.Parse(id)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error()) //returning 400 if conversion fails
+		return err //errInvalidID, the error handler answers 400 if the id is malformed
 	}
 
 	bookUpdate := new(Book) // Create a new Book instance to hold the incoming data for update
-	if err := c.BodyParser(bookUpdate); err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error()) //returning 400 if parsing fails
+	if err := parseBook(c, bookUpdate); err != nil {
+		return err //returning 400 if parsing fails, 422 listing every invalid field
 	}
 
-	// Find the book by ID
-	for index, book := range books {
-		if book.ID == bookID {
-			// Update the book's details
-			books[index].Title = bookUpdate.Title
-			books[index].Author = bookUpdate.Author
-			return c.JSON(books[index]) //returning the updated book as JSON
+	// Update the book's details while the store holds its lock
+	precondition := ifMatch(c)
+	updated, err := h.store.Update(bookID, func(book *Book) error {
+		if err := precondition(*book); err != nil {
+			return err
 		}
+		*book = *bookUpdate //PUT replaces every field, the store keeps the ID and bumps the version
+		return nil
+	})
+	if err != nil {
+		return err //404 if the book is not found, 412 if If-Match is stale
 	}
-	
-	return c.Status(fiber.StatusNotFound).SendString("Book not found") //returning 404 if book not found
+	c.Set(fiber.HeaderETag, bookETag(updated))
+	return c.JSON(updated) //returning the updated book as JSON
 }
 
-func deleteBook(c *fiber.Ctx) error {
-	id:= c.Params("id");
-	// Convert the id to an integer
-	bookId , err := strconv.Atoi(id) 
-	if err != nil { 
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error()) //returning 400 if conversion fails
+// patchBook changes only the fields named in the request body.
+// It accepts a JSON Merge Patch or a JSON Patch, picked by the Content-Type header.
+func (h *bookHandler) patchBook(c *fiber.Ctx) error {
+	id := c.Params("id") //getting the id (string) from the URL
+
+	// Check the id matches the configured ID type
+	bookID, err := h.ids.Parse(id)
+	if err != nil {
+		return err //errInvalidID, the error handler answers 400 if the id is malformed
+	}
+
+	var apply func(doc, patch []byte) ([]byte, error)
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case mimeMergePatch:
+		apply = applyMergePatch
+	case mimeJSONPatch:
+		apply = applyJSONPatch
+	default:
+		c.Set("Accept-Patch", mimeMergePatch+", "+mimeJSONPatch) // tell the client what we understand
+		return probUnsupportedMediaType.with("Use " + mimeMergePatch + " or " + mimeJSONPatch)
 	}
+	patch := c.Body()
 
-	// Loop through the books to find the one with the matching ID
-	for idx , book := range  books {
-		if book.ID == bookId{
-			// Remove the book from the slice by appending the parts before and after it
-			books = append(books[:idx], books[idx+1:]...)
-			return c.SendString("Book deleted successfully") //returning success message
+	// The patch runs inside the store lock, if it fails the stored book stays as it was
+	precondition := ifMatch(c)
+	updated, err := h.store.Update(bookID, func(book *Book) error {
+		if err := precondition(*book); err != nil {
+			return err
+		}
+		doc, err := json.Marshal(book)
+		if err != nil {
+			return err
+		}
+		patched, err := apply(doc, patch)
+		if err != nil {
+			return err
 		}
+		// the patched book must pass the same rules as a created one
+		var result Book
+		if err := decodeAndValidate(patched, &result); err != nil {
+			if errors.As(err, new(validationErrors)) {
+				return err
+			}
+			return fmt.Errorf("%w: %v", errPatchResult, err)
+		}
+		*book = result
+		return nil
+	})
+	if err != nil {
+		// 404 not found, 412 stale If-Match, 400 malformed patch, 409 failed test, 422 bad path or result
+		return err
 	}
+	c.Set(fiber.HeaderETag, bookETag(updated))
+	return c.JSON(updated) //returning the patched book as JSON
+}
 
-	return c.Status(fiber.StatusNotFound).SendString("Book not found") //returning 404 if book not found
+// parseBook reads a book from a JSON body: unknown members are rejected,
+// whitespace is trimmed and the validate rules on Book are checked
+func parseBook(c *fiber.Ctx, book *Book) error {
+	if !c.Is("json") {
+		return probUnsupportedMediaType.with("Send the book as " + fiber.MIMEApplicationJSON)
+	}
+	if err := decodeAndValidate(c.Body(), book); err != nil {
+		if errors.As(err, new(validationErrors)) {
+			return err
+		}
+		return probMalformedRequest.wrap(err)
+	}
+	return nil
 }
-	
 
+func (h *bookHandler) deleteBook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	// Check the id matches the configured ID type
+	bookID, err := h.ids.Parse(id)
+	if err != nil {
+		return err //errInvalidID, the error handler answers 400 if the id is malformed
+	}
+
+	if err := h.store.Delete(bookID, ifMatch(c)); err != nil {
+		return err //404 if the book is not found, 412 if If-Match is stale
+	}
+	return c.SendString("Book deleted successfully") //returning success message
+}
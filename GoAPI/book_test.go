@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// the first sample book, see sampleBooks
+const (
+	sampleID   = "1"
+	sampleISBN = "9780134190440"
+)
+
+// TestBookErrors sends one request for every client error the book routes report
+func TestBookErrors(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	editor := loggedIn(t, app, roleEditor)
+
+	sample := editor.get("/books/"+sampleID).expect(t, fiber.StatusOK)
+	staleETag := `"0"` // versions start at 1
+	valid := Book{Title: "Go", Author: "Gopher"}
+
+	tests := []struct {
+		name      string
+		req       request
+		want      problemType
+		wantField string // the field a validation-failed problem must name
+		wantCode  string // and the code it gives for it
+	}{
+		// GET /books
+		{name: "unknown sort field", req: request{method: fiber.MethodGet, path: "/books?sort=price"}, want: probInvalidQuery},
+		{name: "limit too small", req: request{method: fiber.MethodGet, path: "/books?limit=0"}, want: probInvalidQuery},
+		{name: "limit too large", req: request{method: fiber.MethodGet, path: fmt.Sprintf("/books?limit=%d", maxPageSize+1)}, want: probInvalidQuery},
+		{name: "negative offset", req: request{method: fiber.MethodGet, path: "/books?offset=-1"}, want: probInvalidQuery},
+		{name: "forged cursor", req: request{method: fiber.MethodGet, path: "/books?cursor=not-a-cursor"}, want: probInvalidQuery},
+
+		// GET /books/:id and /books/isbn/:isbn
+		{name: "get malformed id", req: request{method: fiber.MethodGet, path: "/books/abc"}, want: probInvalidID},
+		{name: "get unknown id", req: request{method: fiber.MethodGet, path: "/books/999"}, want: probBookNotFound},
+		{name: "malformed isbn", req: request{method: fiber.MethodGet, path: "/books/isbn/123"}, want: probInvalidISBN},
+		{name: "wrong isbn check digit", req: request{method: fiber.MethodGet, path: "/books/isbn/9780134190441"}, want: probInvalidISBN},
+		{name: "unknown isbn", req: request{method: fiber.MethodGet, path: "/books/isbn/9781491941195"}, want: probBookNotFound},
+
+		// POST /books
+		{name: "create as text", req: request{method: fiber.MethodPost, path: "/books", body: "title=Go", contentType: fiber.MIMETextPlain}, want: probUnsupportedMediaType},
+		{name: "create malformed JSON", req: request{method: fiber.MethodPost, path: "/books", body: `{"title":`}, want: probMalformedRequest},
+		{name: "create without title", req: request{method: fiber.MethodPost, path: "/books", body: Book{Author: "Gopher"}}, want: probValidation, wantField: "title", wantCode: "required"},
+		{name: "create with unknown field", req: request{method: fiber.MethodPost, path: "/books", body: `{"title":"Go","author":"Gopher","price":10}`}, want: probValidation, wantField: "price", wantCode: "unknown_field"},
+		{name: "create with bad isbn", req: request{method: fiber.MethodPost, path: "/books", body: Book{Title: "Go", Author: "Gopher", ISBN: "123"}}, want: probValidation, wantField: "isbn", wantCode: "invalid_isbn"},
+		{name: "create with taken isbn", req: request{method: fiber.MethodPost, path: "/books", body: Book{Title: "Go", Author: "Gopher", ISBN: sampleISBN}}, want: probISBNTaken},
+
+		// PUT /books/:id
+		{name: "update malformed id", req: request{method: fiber.MethodPut, path: "/books/abc", body: valid}, want: probInvalidID},
+		{name: "update as text", req: request{method: fiber.MethodPut, path: "/books/" + sampleID, body: "Go", contentType: fiber.MIMETextPlain}, want: probUnsupportedMediaType},
+		{name: "update malformed JSON", req: request{method: fiber.MethodPut, path: "/books/" + sampleID, body: `[`}, want: probMalformedRequest},
+		{name: "update without author", req: request{method: fiber.MethodPut, path: "/books/" + sampleID, body: Book{Title: "Go"}}, want: probValidation, wantField: "author", wantCode: "required"},
+		{name: "update unknown id", req: request{method: fiber.MethodPut, path: "/books/999", body: valid}, want: probBookNotFound},
+		{name: "update stale If-Match", req: request{method: fiber.MethodPut, path: "/books/" + sampleID, body: valid, header: map[string]string{fiber.HeaderIfMatch: staleETag}}, want: probPreconditionFailed},
+
+		// PATCH /books/:id
+		{name: "patch malformed id", req: request{method: fiber.MethodPatch, path: "/books/abc", body: `{}`, contentType: mimeMergePatch}, want: probInvalidID},
+		{name: "patch as plain JSON", req: request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: `{"pages":1}`}, want: probUnsupportedMediaType},
+		{name: "merge patch malformed", req: request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: `{"pages":`, contentType: mimeMergePatch}, want: probPatchMalformed},
+		{name: "json patch not a list", req: request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: `{"op":"remove"}`, contentType: mimeJSONPatch}, want: probPatchMalformed},
+		{name: "json patch failed test", req: request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: `[{"op":"test","path":"/title","value":"Another title"}]`, contentType: mimeJSONPatch}, want: probPatchTestFailed},
+		{name: "json patch missing path", req: request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: `[{"op":"remove","path":"/nothing/here"}]`, contentType: mimeJSONPatch}, want: probPatchUnprocessable},
+		{name: "patch removes title", req: request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: `{"title":null}`, contentType: mimeMergePatch}, want: probValidation, wantField: "title", wantCode: "required"},
+		{name: "patch unknown id", req: request{method: fiber.MethodPatch, path: "/books/999", body: `{}`, contentType: mimeMergePatch}, want: probBookNotFound},
+		{name: "patch stale If-Match", req: request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: `{}`, contentType: mimeMergePatch, header: map[string]string{fiber.HeaderIfMatch: staleETag}}, want: probPreconditionFailed},
+
+		// DELETE /books/:id
+		{name: "delete malformed id", req: request{method: fiber.MethodDelete, path: "/books/abc"}, want: probInvalidID},
+		{name: "delete unknown id", req: request{method: fiber.MethodDelete, path: "/books/999"}, want: probBookNotFound},
+		{name: "delete stale If-Match", req: request{method: fiber.MethodDelete, path: "/books/" + sampleID, header: map[string]string{fiber.HeaderIfMatch: staleETag}}, want: probPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *editor
+			c.t = t
+			resp := c.do(tt.req)
+			p := resp.expectProblem(t, tt.want)
+			if tt.want == probUnsupportedMediaType && tt.req.method == fiber.MethodPatch && resp.header.Get("Accept-Patch") == "" {
+				t.Error("415 for PATCH without Accept-Patch")
+			}
+			if tt.wantField == "" {
+				return
+			}
+			for _, e := range p.Errors {
+				if e.Field == tt.wantField && e.Code == tt.wantCode {
+					return
+				}
+			}
+			t.Errorf("errors %+v don't report %s %s", p.Errors, tt.wantField, tt.wantCode)
+		})
+	}
+
+	// none of the failed changes touched the sample book
+	if got := editor.get("/books/"+sampleID).expect(t, fiber.StatusOK); string(got.body) != string(sample.body) {
+		t.Errorf("sample book changed from %s to %s", sample.body, got.body)
+	}
+}
+
+// TestBookCSVRoundTrip exports books whose cells look like spreadsheet formulas and imports them
+// into another server: the export must not let a spreadsheet run them, the import must give them back unchanged
+func TestBookCSVRoundTrip(t *testing.T) {
+	t.Parallel()
+	from := loggedIn(t, newTestApp(t), roleEditor)
+	tricky := []Book{
+		{Title: `=HYPERLINK("http://example.com","click")`, Author: "@Mallory", Tags: []string{"-x", "+y"}},
+		{Title: "'=quoted already", Author: "'plain", Publisher: "+1 Press"},
+	}
+	for _, book := range tricky {
+		from.post("/books", book).expect(t, fiber.StatusCreated)
+	}
+
+	export := from.get("/books/export?format=csv").expect(t, fiber.StatusOK)
+	records, err := csv.NewReader(bytes.NewReader(export.body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		for _, cell := range record {
+			if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+				t.Errorf("exported cell %q would run as a formula", cell)
+			}
+		}
+	}
+
+	to := loggedIn(t, newTestApp(t), roleEditor)
+	to.do(request{method: fiber.MethodPost, path: "/books/bulk?mode=best-effort", body: export.body, contentType: mimeCSV}).
+		expect(t, fiber.StatusOK) // the sample books are there already and are reported as duplicates
+	var page bookPage
+	to.get("/books?limit=100").expect(t, fiber.StatusOK).decode(t, &page)
+	for _, want := range tricky {
+		found := false
+		for _, got := range page.Data {
+			if got.Title == want.Title {
+				found = true
+				if got.Author != want.Author || got.Publisher != want.Publisher || !slices.Equal(got.Tags, want.Tags) {
+					t.Errorf("imported %+v, want %+v", got, want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%q was not imported back", want.Title)
+		}
+	}
+}
+
+// TestBookBulkBodyLimit sends one row more than an import allows, in a body above Fiber's default limit:
+// the body must be read, and the row cap must answer, not a 413
+func TestBookBulkBodyLimit(t *testing.T) {
+	t.Parallel()
+	c := loggedIn(t, newTestApp(t), roleEditor)
+	row, err := json.Marshal(Book{Title: strings.Repeat("t", 200), Author: strings.Repeat("a", 100), Publisher: strings.Repeat("p", 100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Repeat(append(row, '\n'), maxBulkRows+1)
+	if len(body) <= fiber.DefaultBodyLimit {
+		t.Fatalf("the body is only %d bytes", len(body))
+	}
+	p := c.do(request{method: fiber.MethodPost, path: "/books/bulk", body: body, contentType: mimeNDJSON}).
+		expectProblem(t, probMalformedRequest)
+	if !strings.Contains(p.Detail, strconv.Itoa(maxBulkRows)) {
+		t.Errorf("detail %q does not name the row cap", p.Detail)
+	}
+}
+
+// TestBookConcurrentWrites runs many writers at once; run it with -race.
+// Every create must get its own ID and every update must be counted in the version.
+func TestBookConcurrentWrites(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	editor := loggedIn(t, app, roleEditor)
+
+	const writers = 8
+	const perWriter = 10
+
+	var wg sync.WaitGroup
+	ids := make(chan BookID, writers*perWriter)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWriter {
+				// t.Fatal must not be called from another goroutine, so failures are reported with Errorf
+				resp := editor.send(request{method: fiber.MethodPost, path: "/books", body: Book{Title: fmt.Sprintf("Book %d-%d", w, i), Author: "Gopher"}})
+				if resp.err != nil || resp.status != fiber.StatusCreated {
+					t.Errorf("create: %v %d %s", resp.err, resp.status, resp.body)
+					continue
+				}
+				var created Book
+				if err := json.Unmarshal(resp.body, &created); err != nil {
+					t.Error(err)
+					continue
+				}
+				ids <- created.ID
+
+				// everybody patches the same book too
+				resp = editor.send(request{method: fiber.MethodPatch, path: "/books/" + sampleID, body: fmt.Sprintf(`{"pages":%d}`, w*perWriter+i+1), contentType: mimeMergePatch})
+				if resp.err != nil || resp.status != fiber.StatusOK {
+					t.Errorf("patch: %v %d %s", resp.err, resp.status, resp.body)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[BookID]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("ID %s was given to two books", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != writers*perWriter {
+		t.Errorf("%d books created, want %d", len(seen), writers*perWriter)
+	}
+
+	var sample Book
+	editor.get("/books/"+sampleID).expect(t, fiber.StatusOK).decode(t, &sample)
+	if want := 1 + writers*perWriter; sample.Version != want {
+		t.Errorf("sample book at version %d after %d patches, want %d", sample.Version, writers*perWriter, want)
+	}
+
+	var page bookPage
+	editor.get("/books?limit=1").expect(t, fiber.StatusOK).decode(t, &page)
+	if want := len(sampleBooks()) + writers*perWriter; page.Meta.Total != want {
+		t.Errorf("%d books listed, want %d", page.Meta.Total, want)
+	}
+}
+
+// TestBookConditionalUpdatesRace lets writers race with the same If-Match: exactly one of them wins
+func TestBookConditionalUpdatesRace(t *testing.T) {
+	t.Parallel()
+	app := newTestApp(t)
+	editor := loggedIn(t, app, roleEditor)
+	etag := editor.get("/books/"+sampleID).expect(t, fiber.StatusOK).header.Get(fiber.HeaderETag)
+
+	const writers = 16
+	statuses := make(chan int, writers)
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := editor.send(request{
+				method: fiber.MethodPatch, path: "/books/" + sampleID, body: fmt.Sprintf(`{"pages":%d}`, w+1),
+				contentType: mimeMergePatch, header: map[string]string{fiber.HeaderIfMatch: etag},
+			})
+			if resp.err != nil {
+				t.Error(resp.err)
+				return
+			}
+			statuses <- resp.status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	won := 0
+	for status := range statuses {
+		switch status {
+		case fiber.StatusOK:
+			won++
+		case fiber.StatusPreconditionFailed:
+		default:
+			t.Errorf("status %d, want 200 or 412", status)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d writers won with the same ETag, want exactly 1", won)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// formats understood by the bulk import and the export
+const (
+	mimeCSV    = "text/csv"
+	mimeNDJSON = "application/x-ndjson"
+)
+
+// bulk import modes
+const (
+	bulkAtomic     = "atomic"      // all rows are created, or none if any row is invalid
+	bulkBestEffort = "best-effort" // valid rows are created, invalid rows are reported
+)
+
+// maxBulkRows caps one import, bigger catalogs are sent in several requests
+const maxBulkRows = 10000
+
+// maxBodySize is the largest request body the server reads (Fiber's default is 4 MB).
+// It allows about 1.6 KB per row at maxBulkRows, more than a book whose every field is filled in;
+// an import of rows with very long titles and tags can still be refused with 413 before it is parsed.
+const maxBodySize = 16 << 20
+
+// csvListSeparator splits array columns such as tags inside a single CSV cell, so tags can't contain it
+const csvListSeparator = "|"
+
+// csvFormulaStart are the first characters that make spreadsheets run a cell as a formula
+const csvFormulaStart = "=+-@\t\r"
+
+// row statuses in the import report
+const (
+	rowCreated = "created"
+	rowInvalid = "invalid"
+	rowSkipped = "skipped" // valid, but not created because another row of an atomic import failed
+)
+
+// rowResult is the outcome of one imported row. Row counts the data rows from 1, a CSV header is not a row.
+type rowResult struct {
+	Row    int          `json:"row"`
+	Status string       `json:"status"`
+	ID     BookID       `json:"id,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// bulkReport is the response of POST /books/bulk
+type bulkReport struct {
+	Mode    string      `json:"mode"`
+	Total   int         `json:"total"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Results []rowResult `json:"results"`
+}
+
+// importBooks handles POST /books/bulk?mode=atomic|best-effort with a CSV or NDJSON body.
+// Every row goes through the same validation as POST /books.
+func (h *bookHandler) importBooks(c *fiber.Ctx) error {
+	mode := c.Query("mode", bulkAtomic)
+	if mode != bulkAtomic && mode != bulkBestEffort {
+		return probInvalidQuery.with("mode must be " + bulkAtomic + " or " + bulkBestEffort)
+	}
+
+	var rows []bulkRow
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case mimeCSV:
+		rows, err = readCSVRows(c.Body())
+	case mimeNDJSON:
+		rows, err = readNDJSONRows(c.Body())
+	default:
+		return probUnsupportedMediaType.with("Send the books as " + mimeCSV + " or " + mimeNDJSON)
+	}
+	if err != nil {
+		return probMalformedRequest.wrap(err) //returning 400 if the file itself can't be read
+	}
+	if len(rows) == 0 {
+		return probMalformedRequest.with("the file contains no books")
+	}
+	if len(rows) > maxBulkRows {
+		return probMalformedRequest.with("at most " + strconv.Itoa(maxBulkRows) + " books per import")
+	}
+
+	// validate everything first, so an atomic import knows whether to write anything at all
+	report := bulkReport{Mode: mode, Total: len(rows), Results: make([]rowResult, len(rows))}
+	books := make([]Book, len(rows))
+	var valid []int // indexes of the rows that passed
+	for i, row := range rows {
+		report.Results[i] = rowResult{Row: i + 1}
+		err := row.err
+		if err == nil {
+			err = decodeAndValidate(row.data, &books[i])
+		}
+		if err != nil {
+			report.Results[i].Status = rowInvalid
+			report.Results[i].Errors = rowErrors(err)
+			report.Failed++
+			continue
+		}
+		valid = append(valid, i)
+	}
+
+	// ISBNs must be unique, within the file and against the catalog
+	owners := map[string]int{} // ISBN -> first row that has it
+	kept := valid[:0]
+	for _, i := range valid {
+		isbn := books[i].ISBN
+		if isbn != "" {
+			_, err := h.store.GetByISBN(isbn)
+			if first, dup := owners[isbn]; dup || err == nil {
+				message := "is already used by another book"
+				if dup {
+					message = "is already used by row " + strconv.Itoa(first+1)
+				}
+				report.Results[i].Status = rowInvalid
+				report.Results[i].Errors = []fieldError{{Field: "isbn", Code: "duplicate", Message: message}}
+				report.Failed++
+				continue
+			}
+			owners[isbn] = i
+		}
+		kept = append(kept, i)
+	}
+	valid = kept
+
+	if mode == bulkAtomic {
+		if report.Failed > 0 {
+			for _, i := range valid {
+				report.Results[i].Status = rowSkipped
+			}
+			rejected := probBulkRejected.with(strconv.Itoa(report.Failed) + " of " + strconv.Itoa(report.Total) + " rows are invalid, nothing was imported")
+			rejected.Extensions = map[string]any{"mode": mode, "total": report.Total, "failed": report.Failed, "results": report.Results}
+			return rejected
+		}
+		// one change for the whole file, so a crash can't leave half of it behind.
+		// The store checks the ISBNs again, another request may have taken one in the meantime.
+		created, err := h.store.CreateMany(books)
+		if err != nil {
+			return err
+		}
+		for i, book := range created {
+			report.Results[i].Status = rowCreated
+			report.Results[i].ID = book.ID
+		}
+		report.Created = len(created)
+		return c.Status(fiber.StatusCreated).JSON(report)
+	}
+
+	for _, i := range valid {
+		created, err := h.store.Create(books[i])
+		if errors.Is(err, ErrISBNTaken) {
+			report.Results[i].Status = rowInvalid
+			report.Results[i].Errors = []fieldError{{Field: "isbn", Code: "duplicate", Message: "is already used by another book"}}
+			report.Failed++
+			continue
+		}
+		if err != nil {
+			return err // a storage failure is not the row's fault, stop and let the client retry
+		}
+		report.Results[i].Status = rowCreated
+		report.Results[i].ID = created.ID
+		report.Created++
+	}
+	return c.JSON(report)
+}
+
+// rowErrors turns the error of a row into field errors for the report
+func rowErrors(err error) []fieldError {
+	var invalid validationErrors
+	if errors.As(err, &invalid) {
+		return invalid
+	}
+	return []fieldError{{Code: "malformed_row", Message: err.Error()}}
+}
+
+// bulkRow is one book of an import as a JSON object, or the error that prevented reading it
+type bulkRow struct {
+	data []byte
+	err  error
+}
+
+// readNDJSONRows reads one JSON object per line, blank lines are ignored
+func readNDJSONRows(body []byte) ([]bulkRow, error) {
+	var rows []bulkRow
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		row := bulkRow{data: append([]byte(nil), line...)}
+		if !json.Valid(line) {
+			row.err = errors.New("the line is not valid JSON")
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// readCSVRows reads a CSV file whose header names the book fields (e.g. title,author).
+// Each record is turned into a JSON object so it goes through the same decoding as JSON bodies:
+// number columns become JSON numbers and array columns are split on csvListSeparator.
+func readCSVRows(body []byte) ([]bulkRow, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reader.FieldsPerRecord = len(header)
+
+	bookType := reflect.TypeOf(Book{})
+	fields := jsonFields(bookType)
+	var rows []bulkRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, err
+		}
+		if err != nil {
+			rows = append(rows, bulkRow{err: parseErr.Err})
+			continue
+		}
+
+		members := make(map[string]any, len(header))
+		for col, name := range header {
+			name = strings.TrimSpace(name)
+			cell := unescapeFormula(record[col])
+			if cell == "" {
+				continue // an empty cell is a missing value
+			}
+			idx, known := fields[name]
+			if !known {
+				members[name] = cell // decodeStrict reports it as an unknown field
+				continue
+			}
+			members[name] = csvValue(bookType.Field(idx).Type, cell)
+		}
+		data, err := json.Marshal(members)
+		rows = append(rows, bulkRow{data: data, err: err})
+	}
+	return rows, nil
+}
+
+// csvValue converts a cell to the JSON type of its field. Cells that don't convert stay strings,
+// so the row fails with invalid_type like a JSON body would.
+func csvValue(t reflect.Type, cell string) any {
+	switch t.Kind() {
+	case reflect.Int:
+		if n, err := strconv.Atoi(strings.TrimSpace(cell)); err == nil {
+			return n
+		}
+	case reflect.Slice:
+		items := strings.Split(cell, csvListSeparator)
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		return items
+	}
+	return cell
+}
+
+// exportBooks handles GET /books/export and streams the whole catalog as NDJSON (default) or CSV.
+// The format comes from ?format=csv|ndjson or else the Accept header.
+func (h *bookHandler) exportBooks(c *fiber.Ctx) error {
+	format := c.Query("format")
+	if format == "" {
+		switch c.Accepts(mimeNDJSON, mimeCSV) {
+		case mimeCSV:
+			format = "csv"
+		default:
+			format = "ndjson"
+		}
+	}
+
+	books := h.store.List() // a consistent copy, the store is not locked while we stream
+	sort.Slice(books, func(i, j int) bool { return books[i].ID.Less(books[j].ID) })
+
+	switch format {
+	case "ndjson":
+		c.Set(fiber.HeaderContentType, mimeNDJSON)
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="books.ndjson"`)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			enc := json.NewEncoder(w) // Encode writes one line per book
+			for i, book := range books {
+				if err := enc.Encode(book); err != nil {
+					return
+				}
+				if i%100 == 99 {
+					if err := w.Flush(); err != nil {
+						return // the client went away
+					}
+				}
+			}
+			w.Flush()
+		})
+	case "csv":
+		c.Set(fiber.HeaderContentType, mimeCSV+"; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="books.csv"`)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			out := csv.NewWriter(w)
+			header, columns := csvColumns()
+			if err := out.Write(header); err != nil {
+				return
+			}
+			for i, book := range books {
+				v := reflect.ValueOf(book)
+				record := make([]string, len(columns))
+				for j, idx := range columns {
+					record[j] = escapeFormula(csvCell(v.Field(idx)))
+				}
+				if err := out.Write(record); err != nil {
+					return
+				}
+				if i%100 == 99 {
+					if out.Flush(); out.Error() != nil {
+						return
+					}
+					if err := w.Flush(); err != nil {
+						return
+					}
+				}
+			}
+			out.Flush()
+			w.Flush()
+		})
+	default:
+		return probInvalidQuery.with("format must be csv or ndjson")
+	}
+	return nil
+}
+
+// csvColumns lists the CSV header and the Book field index of every column, in struct order
+func csvColumns() ([]string, []int) {
+	t := reflect.TypeOf(Book{})
+	var header []string
+	var columns []int
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() && f.Tag.Get("json") != "-" {
+			header = append(header, jsonName(f))
+			columns = append(columns, i)
+		}
+	}
+	return header, columns
+}
+
+// needsFormulaEscape reports whether a cell would run as a formula in a spreadsheet,
+// or starts with a quote that unescapeFormula would take for an escape
+func needsFormulaEscape(cell string) bool {
+	if cell == "" {
+		return false
+	}
+	if cell[0] == '\'' {
+		return needsFormulaEscape(cell[1:])
+	}
+	return strings.IndexByte(csvFormulaStart, cell[0]) >= 0
+}
+
+// escapeFormula prefixes cells like "=HYPERLINK(...)" with a quote, so a spreadsheet opening
+// the export shows them as text instead of running them (CSV injection)
+func escapeFormula(cell string) string {
+	if needsFormulaEscape(cell) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// unescapeFormula undoes escapeFormula, so an exported file imports back unchanged
+func unescapeFormula(cell string) string {
+	if strings.HasPrefix(cell, "'") && needsFormulaEscape(cell[1:]) {
+		return cell[1:]
+	}
+	return cell
+}
+
+// csvCell writes a field the way readCSVRows reads it back
+func csvCell(field reflect.Value) string {
+	switch field.Kind() {
+	case reflect.Int:
+		if field.Int() == 0 {
+			return ""
+		}
+		return strconv.FormatInt(field.Int(), 10)
+	case reflect.Slice:
+		items := make([]string, field.Len())
+		for i := range items {
+			items[i] = field.Index(i).String()
+		}
+		return strings.Join(items, csvListSeparator)
+	}
+	return field.String()
+}
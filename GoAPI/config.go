@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/keyring"
+	"github.com/RookieJoel/GoAPI-essential/shared/loginlimit"
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// settings is every key the server reads, with its type and default.
+// Each one can also be given as a flag, e.g. -port 9090 or -book-store file.
+var settings = append(append([]config.Setting{
+	{Name: "PORT", Type: config.Int, Default: "8080", Min: 1, Max: 65535, Description: "port the server listens on"},
+	{Name: "JWT_ALG", Default: "RS256", Options: []string{keyring.RS256, keyring.EdDSA}, Description: "access token signing algorithm: RS256 or EdDSA"},
+	{Name: "JWT_KEY_DIR", Description: "directory keeping the signing keys, empty keeps them in memory only"},
+	{Name: "JWT_ROTATE_EVERY", Type: config.Duration, Default: "24h", Description: "how long a signing key is used before it is replaced"},
+	{Name: "JWT_KEY_OVERLAP", Type: config.Duration, Default: "1h", Description: "how long a replaced key still verifies tokens, at least ACCESS_TOKEN_TTL"},
+	{Name: "ACCESS_TOKEN_TTL", Type: config.Duration, Default: "15m", Description: "lifetime of access tokens"},
+	{Name: "REFRESH_TOKEN_TTL", Type: config.Duration, Default: "168h", Description: "lifetime of refresh tokens"},
+	{Name: "ADMIN_USERNAME", Default: "admin", Description: "username of the first admin account"},
+	{Name: "ADMIN_PASSWORD", Required: true, Secret: true, Description: "password of the first admin account, there is no default"},
+	{Name: "BCRYPT_COST", Type: config.Int, Default: "10", Min: bcrypt.MinCost, Max: bcrypt.MaxCost, Description: "bcrypt cost of password hashes, every step doubles the time a login takes"},
+	{Name: "BOOK_STORE", Default: "memory", Options: []string{"memory", "file"}, Description: "book storage backend: memory or file"},
+	{Name: "BOOK_DATA_DIR", Default: "data", Description: "directory of the file book store"},
+	{Name: "BOOK_SNAPSHOT_EVERY", Type: config.Int, Default: "100", Min: 1, Description: "changes between two snapshots of the file book store"},
+	{Name: "BOOK_ID_TYPE", Default: "sequential", Options: []string{"sequential", "uuid", "ulid"}, Description: "ID type of new books: sequential, uuid or ulid"},
+	{Name: "SHUTDOWN_TIMEOUT", Type: config.Duration, Default: "10s", Description: "how long requests in flight may finish after SIGTERM before they are cut off"},
+	{Name: "WEBHOOK_DATA_DIR", Description: "directory keeping the webhooks and their delivery queue, empty keeps them in memory only"},
+	{Name: "WEBHOOK_MAX_ATTEMPTS", Type: config.Int, Default: "8", Min: 1, Description: "attempts to deliver an event before it is dead-lettered"},
+	{Name: "WEBHOOK_RETRY_BASE", Type: config.Duration, Default: "10s", Description: "wait before the first retry of a delivery, doubled for every further one"},
+	{Name: "WEBHOOK_RETRY_MAX", Type: config.Duration, Default: "1h", Description: "longest wait between two delivery attempts"},
+	{Name: "WEBHOOK_TIMEOUT", Type: config.Duration, Default: "10s", Description: "how long a webhook receiver may take to answer"},
+	{Name: "WEBHOOK_LOG_SIZE", Type: config.Int, Default: "1000", Min: 1, Description: "delivered and dead deliveries kept in the delivery log"},
+	{Name: "WEBHOOK_ALLOW_PRIVATE", Type: config.Bool, Default: "false", Description: "allow webhooks to loopback, private and link-local addresses, e.g. receivers in the same cluster"},
+	{Name: "SHUTDOWN_DELAY", Type: config.Duration, Description: "how long /readyz fails before the server stops accepting connections on SIGTERM, e.g. 5s behind a load balancer"},
+}, loginlimit.Settings...), reqlog.Settings...)
+
+// checkKeyOverlap: a replaced key must verify tokens for a whole access token lifetime,
+// or tokens signed just before a rotation would die early
+func checkKeyOverlap(cfg *config.Config) error {
+	if cfg.Duration("JWT_KEY_OVERLAP") < cfg.Duration("ACCESS_TOKEN_TTL") {
+		return errors.New("JWT_KEY_OVERLAP must be at least ACCESS_TOKEN_TTL")
+	}
+	return nil
+}
+
+// appConfig is the effective configuration with the origin of every value
+type appConfig struct {
+	*config.Config
+}
+
+// loadConfig resolves every setting: flags win over the environment, which wins over the .env file,
+// which wins over the default. A missing .env file is fine. The error lists every invalid setting.
+func loadConfig(envFile string, args []string) (*appConfig, error) {
+	cfg, err := config.Load(settings, envFile, args, checkKeyOverlap, loginlimit.CheckSettings)
+	if err != nil {
+		return nil, err
+	}
+	return &appConfig{cfg}, nil
+}
+
+// configReport is the body of GET /admin/config
+type configReport struct {
+	Settings []config.Entry `json:"settings"`
+}
+
+// configHandler handles GET /admin/config
+func (cfg *appConfig) configHandler(c *fiber.Ctx) error {
+	return c.JSON(configReport{Settings: cfg.Entries()})
+}
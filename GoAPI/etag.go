@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errPreconditionFailed means the client's If-Match did not match the stored book (412)
+var errPreconditionFailed = errors.New("book was changed by someone else, fetch it again")
+
+// bookETag is the strong entity tag of a book. The version goes up on every change,
+// so two different representations of the same book never share an ETag.
+func bookETag(book Book) string {
+	return `"` + strconv.Itoa(book.Version) + `"`
+}
+
+// ifMatch turns the If-Match request header into a check the store runs under its lock.
+// Without the header the check always passes.
+func ifMatch(c *fiber.Ctx) func(book Book) error {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		return func(Book) error { return nil }
+	}
+	return func(book Book) error {
+		if !etagListMatches(header, bookETag(book), false) {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// notModified reports whether the If-None-Match request header already covers the book
+func notModified(c *fiber.Ctx, book Book) bool {
+	header := c.Get(fiber.HeaderIfNoneMatch)
+	return header != "" && etagListMatches(header, bookETag(book), true)
+}
+
+// etagListMatches checks a comma separated If-Match / If-None-Match header against etag.
+// If-Match uses the strong comparison (weak tags never match), If-None-Match the weak one.
+func etagListMatches(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The tests drive the whole server in-process through app.Test: every route, middleware
+// and the error handler run exactly as behind a real listener, only no port is bound.
+
+// TestMain keeps the request log quiet, only errors such as an unexpected 500 are written
+func TestMain(m *testing.M) {
+	slog.SetDefault(reqlog.New(os.Stderr, "text", "error"))
+	os.Exit(m.Run())
+}
+
+// testArgs are the flags every test app starts with: the admin logs in with "password",
+// EdDSA keys are made in no time, unlike RSA keys under the race detector, passwords are hashed
+// at the lowest bcrypt cost, the login throttling stays out of the way of tests that log in a lot,
+// and webhooks may be sent to the receivers the tests start on 127.0.0.1
+var testArgs = []string{"-admin-password", "password", "-jwt-alg", "EdDSA", "-bcrypt-cost", "4", "-login-ip-burst", "10000", "-login-account-burst", "10000",
+	"-webhook-allow-private", "true"}
+
+// newTestApp builds the server with an empty .env, i.e. the defaults: in-memory stores and keys.
+// args are command-line flags on top of testArgs, e.g. "-access-token-ttl", "1s".
+func newTestApp(t *testing.T, args ...string) *fiber.App {
+	t.Helper()
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(envFile, append(append([]string(nil), testArgs...), args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, _, err := newApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = app.Shutdown() }) // stops the key rotation goroutine
+	return app
+}
+
+// client sends requests to one app, as one user once a token is set
+type client struct {
+	t     *testing.T
+	app   *fiber.App
+	token string
+}
+
+func newClient(t *testing.T, app *fiber.App) *client {
+	return &client{t: t, app: app}
+}
+
+// request is what a test sends: a body of any type is sent as JSON, a string or []byte as is
+type request struct {
+	method      string
+	path        string
+	body        any
+	contentType string            // defaults to application/json when there is a body
+	header      map[string]string // e.g. If-Match
+}
+
+// response is what came back, with the body already read
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+	err    error // the request could not be sent or answered
+}
+
+// do sends the request and fails the test if it got no answer
+func (c *client) do(r request) *response {
+	c.t.Helper()
+	resp := c.send(r)
+	if resp.err != nil {
+		c.t.Fatalf("%s %s: %v", r.method, r.path, resp.err)
+	}
+	return resp
+}
+
+// send sends the request and reports failures in the response only, so goroutines can use it
+func (c *client) send(r request) *response {
+	var body io.Reader
+	contentType := r.contentType
+	switch b := r.body.(type) {
+	case nil:
+	case string:
+		body = bytes.NewBufferString(b)
+	case []byte:
+		body = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return &response{err: err}
+		}
+		body = bytes.NewReader(data)
+	}
+	if body != nil && contentType == "" {
+		contentType = fiber.MIMEApplicationJSON
+	}
+
+	req := httptest.NewRequest(r.method, r.path, body)
+	if contentType != "" {
+		req.Header.Set(fiber.HeaderContentType, contentType)
+	}
+	if c.token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+c.token)
+	}
+	for k, v := range r.header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.app.Test(req, -1) // -1: no timeout, the race detector makes requests slow
+	if err != nil {
+		return &response{err: err}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return &response{status: resp.StatusCode, header: resp.Header, body: data, err: err}
+}
+
+func (c *client) get(path string) *response {
+	c.t.Helper()
+	return c.do(request{method: fiber.MethodGet, path: path})
+}
+
+func (c *client) post(path string, body any) *response {
+	c.t.Helper()
+	return c.do(request{method: fiber.MethodPost, path: path, body: body})
+}
+
+// login logs in and keeps the access token for the following requests
+func (c *client) login(username, password string) loginResponse {
+	c.t.Helper()
+	resp := c.post("/login", credentials{Username: username, Password: password})
+	resp.expect(c.t, fiber.StatusOK)
+	var login loginResponse
+	resp.decode(c.t, &login)
+	if login.AccessToken == "" {
+		c.t.Fatalf("login as %s: no access token in %s", username, resp.body)
+	}
+	c.token = login.AccessToken
+	return login
+}
+
+// loggedIn returns a client logged in with the given role: the admin itself,
+// or a new account named after the role, created by the admin
+func loggedIn(t *testing.T, app *fiber.App, role string) *client {
+	t.Helper()
+	admin := newClient(t, app)
+	admin.login("admin", "password")
+	if role == roleAdmin {
+		return admin
+	}
+	admin.post("/users", userInput{Username: role, Password: "secret-" + role, Role: role}).
+		expect(t, fiber.StatusCreated)
+	c := newClient(t, app)
+	c.login(role, "secret-"+role)
+	return c
+}
+
+func (r *response) expect(t *testing.T, status int) *response {
+	t.Helper()
+	if r.status != status {
+		t.Fatalf("status %d, want %d: %s", r.status, status, r.body)
+	}
+	return r
+}
+
+func (r *response) decode(t *testing.T, v any) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("cannot decode %s: %v", r.body, err)
+	}
+}
+
+// problemBody is an error response as a client sees it
+type problemBody struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail"`
+	Reason string       `json:"reason"` // 403 only
+	Errors []fieldError `json:"errors"` // 422 validation-failed only
+}
+
+// expectProblem checks the response is a problem+json of type typ and returns it
+func (r *response) expectProblem(t *testing.T, typ problemType) problemBody {
+	t.Helper()
+	r.expect(t, typ.status)
+	if ct := r.header.Get(fiber.HeaderContentType); ct != mimeProblem {
+		t.Fatalf("content type %q, want %s", ct, mimeProblem)
+	}
+	var p problemBody
+	r.decode(t, &p)
+	if p.Type != typ.uri() || p.Status != typ.status {
+		t.Fatalf("problem %s (%d), want %s (%d): %s", p.Type, p.Status, typ.uri(), typ.status, r.body)
+	}
+	return p
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BookID identifies a book. Depending on BOOK_ID_TYPE it holds a sequential number, a UUID or a ULID.
+// Sequential IDs are still written as JSON numbers so existing clients keep working.
+type BookID string
+
+// MarshalJSON writes numeric IDs as numbers and everything else as strings
+func (id BookID) MarshalJSON() ([]byte, error) {
+	if id.isNumeric() {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+// UnmarshalJSON accepts both 3 and "3"
+func (id *BookID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = BookID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("book id must be a number or a string")
+	}
+	*id = BookID(n.String())
+	return nil
+}
+
+// Less orders numeric IDs by value and all other IDs lexicographically (ULIDs sort by creation time)
+func (id BookID) Less(other BookID) bool {
+	if id.isNumeric() && other.isNumeric() {
+		if len(id) != len(other) {
+			return len(id) < len(other)
+		}
+	}
+	return id < other
+}
+
+// isNumeric reports whether the ID is a canonical positive integer that fits in an int64
+func (id BookID) isNumeric() bool {
+	if len(id) == 0 || len(id) > 18 || id[0] == '0' {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+var errInvalidID = errors.New("invalid book id")
+
+// idAllocator hands out IDs for new books and validates IDs coming from the URL
+type idAllocator interface {
+	// Next returns a new ID that was never handed out before, even if books were deleted
+	Next() BookID
+	// Parse checks that raw has the format of the configured ID type
+	Parse(raw string) (BookID, error)
+	// Observe tells the allocator about an ID loaded from disk so it never hands it out again
+	Observe(id BookID)
+	// Last returns the allocator state that must be persisted, empty if there is none
+	Last() BookID
+}
+
+// newIDAllocator picks the ID type from BOOK_ID_TYPE: "sequential" (default), "uuid" or "ulid"
+func newIDAllocator(kind string) (idAllocator, error) {
+	switch kind {
+	case "", "sequential":
+		return &sequentialIDs{}, nil
+	case "uuid":
+		return uuidIDs{}, nil
+	case "ulid":
+		return &ulidIDs{}, nil
+	default:
+		return nil, fmt.Errorf("unknown BOOK_ID_TYPE %q (want \"sequential\", \"uuid\" or \"ulid\")", kind)
+	}
+}
+
+// sequentialIDs is a monotonic counter: 1, 2, 3, ...
+// it only ever goes up, so a deleted book's ID is never reused
+type sequentialIDs struct {
+	mu   sync.Mutex
+	last int64
+}
+
+func (a *sequentialIDs) Next() BookID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.last++
+	return BookID(strconv.FormatInt(a.last, 10))
+}
+
+func (a *sequentialIDs) Parse(raw string) (BookID, error) {
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		return "", errInvalidID
+	}
+	return BookID(strconv.FormatInt(n, 10)), nil
+}
+
+func (a *sequentialIDs) Observe(id BookID) {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	if err != nil {
+		return // not one of ours, e.g. left over from a different BOOK_ID_TYPE
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n > a.last {
+		a.last = n
+	}
+}
+
+func (a *sequentialIDs) Last() BookID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.last == 0 {
+		return ""
+	}
+	return BookID(strconv.FormatInt(a.last, 10))
+}
+
+// uuidIDs hands out random (version 4) UUIDs
+type uuidIDs struct{}
+
+func (uuidIDs) Next() BookID { return BookID(uuid.NewString()) }
+
+func (uuidIDs) Parse(raw string) (BookID, error) {
+	u, err := uuid.Parse(raw)
+	if err != nil {
+		return "", errInvalidID
+	}
+	return BookID(u.String()), nil // canonical lower-case form
+}
+
+func (uuidIDs) Observe(BookID) {}
+
+func (uuidIDs) Last() BookID { return "" }
+
+// crockford is the base32 alphabet used by ULIDs (no I, L, O or U)
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidIDs hands out ULIDs: 48 bits of milliseconds followed by 80 random bits.
+// IDs created in the same millisecond increment the random part so they stay sorted.
+type ulidIDs struct {
+	mu       sync.Mutex
+	lastMS   uint64
+	lastRand [10]byte
+}
+
+func (a *ulidIDs) Next() BookID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= a.lastMS {
+		// same (or an earlier, if the clock went back) millisecond: bump the random part
+		ms = a.lastMS
+		for i := len(a.lastRand) - 1; i >= 0; i-- {
+			a.lastRand[i]++
+			if a.lastRand[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := rand.Read(a.lastRand[:]); err != nil {
+			panic(err) // crypto/rand never fails on supported platforms
+		}
+	}
+	a.lastMS = ms
+
+	var raw [16]byte
+	binary.BigEndian.PutUint16(raw[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(raw[2:6], uint32(ms))
+	copy(raw[6:], a.lastRand[:])
+	return BookID(encodeULID(raw))
+}
+
+func (a *ulidIDs) Parse(raw string) (BookID, error) {
+	if len(raw) != 26 || raw[0] > '7' { // the first character only carries 3 bits
+		return "", errInvalidID
+	}
+	upper := strings.ToUpper(raw)
+	for _, r := range upper {
+		if !strings.ContainsRune(crockford, r) {
+			return "", errInvalidID
+		}
+	}
+	return BookID(upper), nil
+}
+
+func (a *ulidIDs) Observe(BookID) {}
+
+func (a *ulidIDs) Last() BookID { return "" }
+
+// encodeULID writes the 128 bits as 26 base32 characters, 5 bits at a time from the top
+func encodeULID(raw [16]byte) string {
+	out := make([]byte, 26)
+	// 130 bits of output for 128 bits of input, so the first character holds the top 3 bits
+	var acc uint64
+	bits := 2 // pretend two leading zero bits
+	pos := 0
+	for _, b := range raw {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(acc>>uint(bits))&31]
+			pos++
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseIDs(t *testing.T) {
+	tests := []struct {
+		kind string
+		raw  string
+		want BookID // empty for errInvalidID
+	}{
+		{"sequential", "1", "1"},
+		{"sequential", "42", "42"},
+		{"sequential", "007", "7"}, // canonical form, so /books/007 is book 7
+		{"sequential", "0", ""},
+		{"sequential", "-3", ""},
+		{"sequential", "abc", ""},
+		{"sequential", "99999999999999999999", ""}, // beyond int64
+		{"uuid", "0B9E3C8E-5B8F-4C1A-9D2E-3F4A5B6C7D8E", "0b9e3c8e-5b8f-4c1a-9d2e-3f4a5b6c7d8e"},
+		{"uuid", "0b9e3c8e5b8f4c1a9d2e3f4a5b6c7d8e", "0b9e3c8e-5b8f-4c1a-9d2e-3f4a5b6c7d8e"},
+		{"uuid", "1", ""},
+		{"uuid", "0b9e3c8e-5b8f-4c1a-9d2e-3f4a5b6c7d8", ""},
+		{"ulid", "01arz3ndektsv4rrffq69g5fav", "01ARZ3NDEKTSV4RRFFQ69G5FAV"},
+		{"ulid", "01ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAV"},
+		{"ulid", "81ARZ3NDEKTSV4RRFFQ69G5FAV", ""}, // more than 128 bits
+		{"ulid", "01ARZ3NDEKTSV4RRFFQ69G5FAU", ""}, // U isn't in the alphabet
+		{"ulid", "01ARZ3NDEKTSV4RRFFQ69G5FA", ""},  // too short
+		{"ulid", "1", ""},
+	}
+	for _, tt := range tests {
+		ids, err := newIDAllocator(tt.kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ids.Parse(tt.raw)
+		if tt.want == "" {
+			if !errors.Is(err, errInvalidID) {
+				t.Errorf("%s Parse(%q) = %q, %v, want errInvalidID", tt.kind, tt.raw, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s Parse(%q) = %q, %v, want %q", tt.kind, tt.raw, got, err, tt.want)
+		}
+	}
+	if _, err := newIDAllocator("serial"); err == nil {
+		t.Error("unknown BOOK_ID_TYPE accepted")
+	}
+}
+
+func TestSequentialIDs(t *testing.T) {
+	ids := &sequentialIDs{}
+	if ids.Last() != "" {
+		t.Errorf("Last() = %q before the first ID, want empty", ids.Last())
+	}
+	if got := ids.Next(); got != "1" {
+		t.Errorf("first ID %q, want 1", got)
+	}
+	ids.Observe("10") // loaded from disk
+	ids.Observe("4")  // older, changes nothing
+	ids.Observe("0b9e3c8e-5b8f-4c1a-9d2e-3f4a5b6c7d8e")
+	if got := ids.Next(); got != "11" {
+		t.Errorf("ID after observing 10 is %q, want 11", got)
+	}
+	if got := ids.Last(); got != "11" {
+		t.Errorf("Last() = %q, want 11", got)
+	}
+}
+
+func TestULIDs(t *testing.T) {
+	if got := encodeULID([16]byte{}); got != "00000000000000000000000000" {
+		t.Errorf("zero ULID %q", got)
+	}
+	top := [16]byte{}
+	for i := range top {
+		top[i] = 0xff
+	}
+	if got := encodeULID(top); got != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("largest ULID %q", got)
+	}
+
+	// many IDs in the same millisecond still sort in the order they were made
+	ids := &ulidIDs{}
+	prev := ids.Next()
+	for range 1000 {
+		next := ids.Next()
+		if !prev.Less(next) {
+			t.Fatalf("%s came after %s", next, prev)
+		}
+		if _, err := ids.Parse(string(next)); err != nil {
+			t.Fatalf("Parse(%s) = %v", next, err)
+		}
+		prev = next
+	}
+}
+
+func TestBookIDJSON(t *testing.T) {
+	tests := []struct {
+		id   BookID
+		json string
+	}{
+		{"42", `42`}, // sequential IDs stay numbers for existing clients
+		{"0b9e3c8e-5b8f-4c1a-9d2e-3f4a5b6c7d8e", `"0b9e3c8e-5b8f-4c1a-9d2e-3f4a5b6c7d8e"`},
+		{"01ARZ3NDEKTSV4RRFFQ69G5FAV", `"01ARZ3NDEKTSV4RRFFQ69G5FAV"`},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.id)
+		if err != nil || string(data) != tt.json {
+			t.Errorf("Marshal(%q) = %s, %v, want %s", tt.id, data, err, tt.json)
+		}
+		var back BookID
+		if err := json.Unmarshal(data, &back); err != nil || back != tt.id {
+			t.Errorf("Unmarshal(%s) = %q, %v", data, back, err)
+		}
+	}
+	var id BookID
+	if err := json.Unmarshal([]byte(`"42"`), &id); err != nil || id != "42" {
+		t.Errorf(`Unmarshal("42") = %q, %v`, id, err)
+	}
+	if err := json.Unmarshal([]byte(`true`), &id); err == nil {
+		t.Error("a boolean was accepted as a book id")
+	}
+
+	// numbers by value, not as text
+	if !BookID("9").Less("10") || BookID("10").Less("9") {
+		t.Error("9 and 10 are out of order")
+	}
+}
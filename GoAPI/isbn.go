@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var errInvalidISBN = errors.New("not a valid ISBN-10 or ISBN-13")
+
+// normalizeISBN checks an ISBN-10 or ISBN-13 and returns it as 13 digits without hyphens.
+// Spaces and hyphens are ignored, so "0-13-419044-0" and "978-0134190440" both give "9780134190440".
+func normalizeISBN(raw string) (string, error) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(raw))
+	switch len(isbn) {
+	case 10:
+		if !validISBN10(isbn) {
+			return "", errInvalidISBN
+		}
+		// an ISBN-10 is an ISBN-13 with the 978 prefix and a different check digit
+		isbn = "978" + isbn[:9]
+		return isbn + string(isbn13CheckDigit(isbn)), nil
+	case 13:
+		if !allDigits(isbn) || (!strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979")) {
+			return "", errInvalidISBN
+		}
+		if isbn13CheckDigit(isbn[:12]) != isbn[12] {
+			return "", errInvalidISBN
+		}
+		return isbn, nil
+	}
+	return "", errInvalidISBN
+}
+
+// validISBN10 checks the mod 11 checksum: the digits weighted 10 down to 1 must sum to a multiple of 11.
+// The last character may be X, which stands for 10.
+func validISBN10(isbn string) bool {
+	if !allDigits(isbn[:9]) {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(isbn[i]-'0') * (10 - i)
+	}
+	switch last := isbn[9]; {
+	case last == 'X':
+		sum += 10
+	case last >= '0' && last <= '9':
+		sum += int(last - '0')
+	default:
+		return false
+	}
+	return sum%11 == 0
+}
+
+// isbn13CheckDigit computes the last digit of an ISBN-13 from its first 12 digits (weights 1 and 3)
+func isbn13CheckDigit(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		digit := int(first12[i] - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
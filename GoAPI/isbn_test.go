@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		raw, want string // want is empty for an invalid ISBN
+	}{
+		{"9780134190440", "9780134190440"},
+		{"978-0-13-419044-0", "9780134190440"},
+		{"978 0134190440", "9780134190440"},
+		{"0134190440", "9780134190440"}, // ISBN-10 to ISBN-13
+		{"0-13-419044-0", "9780134190440"},
+		{"080442957X", "9780804429573"}, // X stands for 10
+		{"080442957x", "9780804429573"},
+		{"9791032305690", "9791032305690"}, // 979 prefix
+		{"0000000000", "9780000000002"},
+
+		{"9780134190441", ""}, // wrong check digit
+		{"0134190441", ""},    // wrong check digit
+		{"0804429571", ""},    // X replaced by a digit
+		{"X134190440", ""},    // X only as the check digit
+		{"9770134190440", ""}, // not a book prefix
+		{"97801341904a0", ""},
+		{"978013419044", ""},   // 12 digits
+		{"97801341904400", ""}, // 14 digits
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := normalizeISBN(tt.raw)
+		if tt.want == "" {
+			if !errors.Is(err, errInvalidISBN) {
+				t.Errorf("normalizeISBN(%q) = %q, %v; want errInvalidISBN", tt.raw, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeISBN(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestISBN13CheckDigit(t *testing.T) {
+	for first12, want := range map[string]byte{
+		"978013419044": '0',
+		"978080442957": '3',
+		"979103230569": '0',
+		"978000000000": '2',
+		"978316148410": '0', // the usual example, 978-3-16-148410-0
+	} {
+		if got := isbn13CheckDigit(first12); got != want {
+			t.Errorf("isbn13CheckDigit(%s) = %c, want %c", first12, got, want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/loginlimit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// tooManyAttempts answers a refused login with 429, Retry-After in whole seconds
+// and the reason, so a client can tell a busy IP from a locked account
+func tooManyAttempts(c *fiber.Ctx, d loginlimit.Decision) error {
+	retryAfter := int(math.Ceil(d.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+	p := probTooManyAttempts.with("Try again in " + strconv.Itoa(retryAfter) + " seconds")
+	p.Extensions = map[string]any{"reason": d.Reason, "retry_after": retryAfter}
+	return p
+}
+
+// lockoutList is the body of GET /admin/lockouts
+type lockoutList struct {
+	Data []loginlimit.Record `json:"data"`
+}
+
+// listLockouts handles GET /admin/lockouts: locked accounts, and IPs and accounts with failed or used up attempts
+func (h *userHandler) listLockouts(c *fiber.Ctx) error {
+	return c.JSON(lockoutList{Data: h.limiter.Records()})
+}
+
+// clearLockout handles DELETE /admin/lockouts/:kind/:id, kind is ip or account.
+// It unlocks the account (or IP) and gives it its full attempts back.
+func (h *userHandler) clearLockout(c *fiber.Ctx) error {
+	kind := c.Params("kind")
+	if kind != loginlimit.KindIP && kind != loginlimit.KindAccount {
+		return probInvalidID.with("kind must be " + loginlimit.KindIP + " or " + loginlimit.KindAccount)
+	}
+	if !h.limiter.Clear(kind, c.Params("id")) {
+		return probLockoutNotFound.with("nothing is recorded for " + kind + " " + strconv.Quote(c.Params("id")))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"time"
+	"log/slog"
+	"os"
 
 	// "net/http"
-	"os"
 
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/graceful"
+	"github.com/RookieJoel/GoAPI-essential/shared/health"
+	"github.com/RookieJoel/GoAPI-essential/shared/loginlimit"
+	"github.com/RookieJoel/GoAPI-essential/shared/metrics"
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
 	"github.com/gofiber/fiber/v2" //import fiber
-	"github.com/gofiber/jwt/v2"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/joho/godotenv" //import godotenv for loading environment variables
 )
 
 //this is like using pure http package
@@ -37,142 +44,232 @@ import (
 // Book struct to hold book data
 type Book struct {
 	// response data will be in JSON format as indicated by the tags
-	ID     int    `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author"`
+	// the validate tags are the rules for create, update and patch, see validate.go
+	ID        BookID   `json:"id"`
+	Title     string   `json:"title" validate:"required,max=200"`
+	Author    string   `json:"author" validate:"required,max=100"`
+	ISBN      string   `json:"isbn,omitempty" validate:"isbn"` // always stored as an ISBN-13, unique in the store
+	Year      int      `json:"year,omitempty" validate:"year"` // year of publication
+	Publisher string   `json:"publisher,omitempty" validate:"max=100"`
+	Language  string   `json:"language,omitempty" validate:"language"` // ISO 639 code, e.g. "en"
+	Pages     int      `json:"pages,omitempty" validate:"min=1,max=100000"`
+	Tags      []string `json:"tags,omitempty" validate:"max=20,tags"`
+	Version   int      `json:"version"` // goes up by one on every change, used for the ETag
 }
 
-// Sample book data (in-memory)
-var books []Book
-
 func main() {
 
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+	// Load the configuration: flags, then environment variables, then the .env file (optional), then the defaults.
+	// Every invalid or missing setting is listed at once and the server doesn't start.
+	cfg, err := loadConfig(".env", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(config.Usage(settings)) // -h
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	
+
 	// http.HandleFunc("/greet", helloHandler)
-	
+
 	// port := ":8080"
 	// fmt.Printf("Server is running on port %s\n", port)
 	// if err := http.ListenAndServe(port, nil); err != nil {
 	// 	log.Fatalf("Failed to start server: %v", err)
 	// }
 
+	// JSON lines on stdout (LOG_FORMAT, LOG_LEVEL), log.Printf included
+	reqlog.Setup(cfg.Config)
+
+	app, checks, err := newApp(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	port := ":" + cfg.Get("PORT")
+	fmt.Printf("Server is running on port %s\n", port)
+	// on SIGTERM: fail /readyz for SHUTDOWN_DELAY, stop accepting connections, let requests finish, then close the stores
+	err = graceful.ListenAndServe(app, port, cfg.Duration("SHUTDOWN_TIMEOUT"),
+		graceful.OnStop(checks.Drain), graceful.Delay(cfg.Duration("SHUTDOWN_DELAY")))
+	if err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
+}
 
+// newApp wires the stores, the token service and every route.
+// main serves it, the tests send requests to the very same app with app.Test.
+// The readiness checks are returned so main can make them fail when the server stops.
+func newApp(cfg *appConfig) (*fiber.App, *health.Checker, error) {
 	// Using Fiber framework
 	// this is like using express in Node.js
 	//code down below is auto error handled, no need to check for errors like in pure http package
-	app := fiber.New() //this is like app = express()
+	app := fiber.New(fiber.Config{
+		ErrorHandler: errorHandler, //every error is answered as application/problem+json
+		BodyLimit:    maxBodySize,  //room for an import of maxBulkRows books
+	}) //this is like app = express()
+
+	//one log line per request, tagged with its X-Request-ID and the username of the token
+	app.Use(reqlog.Middleware(slog.Default(), tokenSubject))
+
+	//requests per route and status, latencies and requests in flight, scraped by Prometheus from /metrics
+	stats := metrics.New()
+	app.Use(stats.Middleware())
+	app.Get("/metrics", stats.Handler())
+
+	//probes: /healthz answers while the process runs, /readyz runs the checks registered below
+	checks := health.New(health.DefaultTimeout)
+	app.Get("/healthz", checks.Live)
+	app.Get("/readyz", checks.Ready)
+
 	app.Get("/greet", func(c *fiber.Ctx) error {
 		return c.SendString("Hello, World! You've reached the Go API server.")
-		});
-		
-		//CRUD operations
-		
-		books = append(books ,Book{
-			ID:     1,
-			Title:  "The Go Programming Language",
-			Author: "Alan Donovan",
-		},
-		Book{
-			ID:     2,
-			Title:  "Learning Go",
-			Author: "Jon Bodner",
-		},
-		Book{
-			ID:     3,
-			Title:  "Go in Action",
-			Author: "William Kennedy",
-		},
-	)
-	
+	})
+
+	//API documentation: the OpenAPI document of every route below and Swagger UI to try them out
+	app.Get("/openapi.json", openAPIHandler(app))
+	app.Get("/docs", docsHandler)
+	app.Get("/docs/:file", docsAsset) // Swagger UI itself, embedded in the binary
+
+	//CRUD operations
+
+	// Books are kept behind a BookStore so concurrent requests are safe.
+	// BOOK_STORE=file keeps them on disk across restarts, the default is in-memory sample data
+	// BOOK_ID_TYPE picks sequential (default), uuid or ulid IDs for new books
+	ids, err := newIDAllocator(cfg.Get("BOOK_ID_TYPE"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error configuring book IDs: %w", err)
+	}
+	store, err := openBookStore(cfg, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening book store: %w", err)
+	}
+	// the file store writes a last snapshot and closes its journal once the requests are drained
+	if closer, ok := store.(io.Closer); ok {
+		app.Hooks().OnShutdown(closer.Close)
+	}
+	// and it is only ready while it can write them
+	if checker, ok := store.(interface{ Check(context.Context) error }); ok {
+		checks.Register("book_store", checker.Check)
+	}
+	search := newSearchIndex(store) // built from the store and updated with every change
+	bookHandler := newBookHandler(store, ids, search)
+
+	// every book change is queued for the webhooks subscribed to it and sent in the background
+	webhooks, err := newWebhookDispatcherFromConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening webhooks: %w", err)
+	}
+	store.subscribe(func([]Book) {}, webhooks.onChange) // only told about changes the journal already has
+	go webhooks.Run()
+	app.Hooks().OnShutdown(webhooks.Close)
+
 	//read all books
 	// app.Get("/books", func(c *fiber.Ctx) error {
 	// 	return c.JSON(books) //returning books as JSON
 	// });
 
+	// user accounts, starting with one admin from ADMIN_USERNAME / ADMIN_PASSWORD
+	users := newMemoryUserStore()
+	passwords, err := newPasswordHasher(cfg.Int("BCRYPT_COST"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error configuring password hashing: %w", err)
+	}
+	adminUser := cfg.Get("ADMIN_USERNAME")
+	if err := seedAdmin(users, passwords, adminUser, cfg.Get("ADMIN_PASSWORD")); err != nil {
+		return nil, nil, fmt.Errorf("error creating admin user: %w", err)
+	}
+
+	// access tokens are signed with rotating RS256/EdDSA keys, their public halves are published as a JWKS
+	keys, err := newKeyRingFromConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error configuring signing keys: %w", err)
+	}
+	stopRotation := make(chan struct{})
+	go keys.Run(stopRotation) // rotate in the background until the server shuts down
+	app.Hooks().OnShutdown(func() error {
+		close(stopRotation)
+		return nil
+	})
+
+	// access tokens live for ACCESS_TOKEN_TTL, refresh tokens rotate on every use
+	tokens := newTokenServiceFromConfig(cfg, users, keys)
+	// login attempts per client IP and per account, with lockouts after repeated failures.
+	// The state is kept in memory; a shared loginlimit.Store would make the limits hold across instances.
+	limiter := loginlimit.FromConfig(cfg.Config, nil)
+	userHandler := newUserHandler(users, passwords, tokens, limiter)
+
 	//login
-	app.Post("/login", login)
+	app.Post("/login", userHandler.login)
+
+	//create a reader account
+	app.Post("/register", userHandler.register)
+
+	//exchange a refresh token for a new access/refresh token pair
+	app.Post("/token/refresh", tokens.refreshHandler)
+
+	//public signing keys so other services can verify our tokens
+	app.Get("/.well-known/jwks.json", keys.Handler)
 
 	//Middleware for JWT authentication
-	app.Use(jwtware.New(jwtware.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")), //get JWT secret from environment
-	}))
+	//revoked tokens are still validly signed, so the success handler checks the denylist
+	app.Use(newJWTMiddleware(keys, tokens.rejectRevoked))
+
+	//revoke the current access token and its refresh token
+	app.Post("/logout", tokens.logoutHandler)
 
 	//or you can use a separate function for the handler
-	app.Get("/books", getBooks) //using a separate function for the handler
-	app.Get("/books/:id", getBookByID) 
+	app.Get("/books", bookHandler.getBooks) //using a separate function for the handler
 
-	//create a new book
-	app.Post("/books", createBook)
+	//full-text search, best matches first
+	app.Get("/books/search", bookHandler.searchBooks)
 
-	//update a book
-	app.Put("books/:id", updateBook) 
+	//stream the whole catalog as NDJSON or CSV, registered before /books/:id so "export" isn't taken for an id
+	app.Get("/books/export", bookHandler.exportBooks)
+	app.Get("/books/:id", bookHandler.getBookByID)
 
-	//delete a book
-	app.Delete("/books/:id",deleteBook )
-	
-	//get environment variable
-	app.Get("/env", getEnv)
+	//find a book by its ISBN-10 or ISBN-13
+	app.Get("/books/isbn/:isbn", bookHandler.getBookByISBN)
 
-	
-	
-	
-	port := ":8080"
-	fmt.Printf("Server is running on port %s\n", port)
-	if err := app.Listen(port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-}
+	//create a new book (editors and admins)
+	app.Post("/books", requireRole(roleEditor), bookHandler.createBook)
 
-func getEnv(c *fiber.Ctx) error {
-	// Get the SECRET environment variable
-	secret := os.Getenv("SECRET")
-	if secret == "" {
-		return c.Status(fiber.StatusInternalServerError).SendString("SECRET not set")
-	}
-	return c.JSON(fiber.Map{
-		"SECRET" : secret,
-	})
-}
+	//import many books from CSV or NDJSON, ?mode=atomic (default) or best-effort (editors and admins)
+	app.Post("/books/bulk", requireRole(roleEditor), bookHandler.importBooks)
 
-type User struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
+	//update a book (editors and admins)
+	app.Put("/books/:id", requireRole(roleEditor), bookHandler.updateBook)
 
-//dummy user for login
-var memberUser = User{
-	Username: "admin",
-	Password: "password",
-}
+	//partially update a book (JSON Merge Patch or JSON Patch, editors and admins)
+	app.Patch("/books/:id", requireRole(roleEditor), bookHandler.patchBook)
 
-func login(c *fiber.Ctx) error {
-	user := new(User)
-	if err := c.BodyParser(user); err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
-	}
+	//delete a book (editors and admins)
+	app.Delete("/books/:id", requireRole(roleEditor), bookHandler.deleteBook)
 
-	if user.Username != memberUser.Username && user.Password != memberUser.Password {
-		return fiber.ErrUnauthorized
-	}
+	//user management, admins only
+	admin := app.Group("/users", requireRole(roleAdmin))
+	admin.Get("/", userHandler.listUsers)
+	admin.Get("/:id", userHandler.getUser)
+	admin.Post("/", userHandler.createUser)
+	admin.Put("/:id", userHandler.updateUser)
+	admin.Delete("/:id", userHandler.deleteUser)
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": user.Username,
-		"exp":      jwt.TimeFunc().Add(24 * time.Hour).Unix(), // token expires in 24 hours
-	})
+	//webhooks for book.created, book.updated and book.deleted, and the log of their deliveries, admins only
+	hooks := app.Group("/webhooks", requireRole(roleAdmin))
+	hooks.Get("/", webhooks.listWebhooks)
+	hooks.Post("/", webhooks.createWebhook)
+	hooks.Get("/deliveries", webhooks.listDeliveries) //registered before /:id so "deliveries" isn't taken for an id
+	hooks.Get("/deliveries/:id", webhooks.getDelivery)
+	hooks.Post("/deliveries/:id/retry", webhooks.retryDelivery)
+	hooks.Get("/:id", webhooks.getWebhook)
+	hooks.Delete("/:id", webhooks.deleteWebhook)
 
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Could not generate token")
-	}
+	//effective configuration and where each value came from, secrets only as fingerprints
+	app.Get("/admin/config", requireRole(roleAdmin), cfg.configHandler)
 
-	return c.JSON(fiber.Map{
-		"message": "Login successful",
-		"username": user.Username,
-		"token": tokenString,
-	})
-}
\ No newline at end of file
+	//inspect and clear login lockouts, e.g. DELETE /admin/lockouts/account/alice
+	app.Get("/admin/lockouts", requireRole(roleAdmin), userHandler.listLockouts)
+	app.Delete("/admin/lockouts/:kind/:id", requireRole(roleAdmin), userHandler.clearLockout)
+
+	return app, checks, nil
+}
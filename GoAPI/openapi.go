@@ -0,0 +1,610 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/health"
+	"github.com/RookieJoel/GoAPI-essential/shared/keyring"
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiOperation documents one route. The OpenAPI document is built from these and the Go types they name,
+// and openapi_test.go fails when a registered route has no entry, so the docs can't fall behind.
+type apiOperation struct {
+	summary      string
+	tag          string
+	role         string        // least role that may call the route, "" for public routes
+	params       []apiParam    // query parameters and headers, path parameters come from the route
+	request      any           // a value of the body type, nil for routes without a body
+	requestTypes []string      // content types of the body, application/json by default
+	status       int           // success status, 200 by default
+	response     any           // a value of the success body type, nil for an empty or plain text body
+	produces     []string      // content types of the success body, application/json by default
+	also         map[int]any   // other success statuses, e.g. 304, and a value of their JSON body type, nil for none
+	problems     []problemType // failures of the route itself, the auth failures are added from role
+}
+
+// apiParam is a query parameter or header of an operation
+type apiParam struct {
+	name        string
+	in          string // "query" or "header"
+	description string
+	schema      map[string]any
+}
+
+var (
+	stringSchema  = map[string]any{"type": "string"}
+	integerSchema = map[string]any{"type": "integer"}
+)
+
+// parameters shared by several routes
+var (
+	limitParam       = apiParam{"limit", "query", "Page size, 1 to " + strconv.Itoa(maxPageSize), map[string]any{"type": "integer", "minimum": 1, "maximum": maxPageSize, "default": defaultPageSize}}
+	offsetParam      = apiParam{"offset", "query", "Position of the first result", map[string]any{"type": "integer", "minimum": 0, "default": 0}}
+	ifMatchParam     = apiParam{"If-Match", "header", "ETag of the book as last read, the change fails with 412 if it is stale", stringSchema}
+	ifNoneMatchParam = apiParam{"If-None-Match", "header", "ETag of a cached copy, answered with 304 if it is still current", stringSchema}
+)
+
+// apiOps documents every route by "METHOD /path/{param}"
+var apiOps = map[string]apiOperation{
+	"GET /greet": {
+		summary: "Say hello", tag: "meta",
+		produces: []string{fiber.MIMETextPlainCharsetUTF8},
+	},
+	"GET /openapi.json": {
+		summary: "This OpenAPI document", tag: "meta",
+		response: map[string]any{},
+	},
+	"GET /docs": {
+		summary: "Interactive documentation (Swagger UI)", tag: "meta",
+		produces: []string{fiber.MIMETextHTMLCharsetUTF8},
+	},
+	"GET /docs/{file}": {
+		summary: "Script and stylesheet of Swagger UI, served from the binary", tag: "meta",
+		produces: []string{"text/css", "application/javascript"},
+	},
+	"GET /metrics": {
+		summary: "Request counts and latencies per route, in the Prometheus text format", tag: "meta",
+		produces: []string{"text/plain; version=0.0.4; charset=utf-8"},
+	},
+	"GET /healthz": {
+		summary: "Liveness: the server is running", tag: "meta",
+		response: map[string]any{},
+	},
+	"GET /readyz": {
+		summary: "Readiness: the result of every check, 503 when one fails or the server is shutting down", tag: "meta",
+		response: health.Report{},
+	},
+	"POST /login": {
+		summary: "Log in and get an access and refresh token", tag: "auth",
+		request: credentials{}, response: loginResponse{},
+		problems: []problemType{probMalformedRequest, probBadCredentials, probTooManyAttempts},
+	},
+	"POST /register": {
+		summary: "Create a reader account", tag: "auth",
+		request: credentials{}, status: fiber.StatusCreated, response: User{},
+		problems: []problemType{probMalformedRequest, probInvalidUser, probUsernameTaken},
+	},
+	"POST /token/refresh": {
+		summary: "Exchange a refresh token for a new token pair", tag: "auth",
+		request: refreshRequest{}, response: tokenPair{},
+		problems: []problemType{probMalformedRequest, probInvalidRefreshToken},
+	},
+	"GET /.well-known/jwks.json": {
+		summary: "Public keys that verify the access tokens", tag: "auth",
+		response: keyring.JWKSet{},
+	},
+	"POST /logout": {
+		summary: "Revoke the current access token and its refresh token", tag: "auth", role: roleReader,
+		status: fiber.StatusNoContent,
+	},
+	"GET /books": {
+		summary: "List books with filters, sorting and pagination", tag: "books", role: roleReader,
+		params: []apiParam{
+			{"author", "query", "Only books whose author contains this text", stringSchema},
+			{"title", "query", "Only books whose title contains this text", stringSchema},
+			{"q", "query", "Only books whose title or author contains every word", stringSchema},
+			{"sort", "query", "Comma separated id, title or author, a leading - sorts descending", stringSchema},
+			limitParam, offsetParam,
+			{"cursor", "query", "Opaque cursor from the next or prev link, wins over offset", stringSchema},
+		},
+		response: bookPage{},
+		problems: []problemType{probInvalidQuery},
+	},
+	"GET /books/search": {
+		summary: "Full-text search, best matches first", tag: "books", role: roleReader,
+		params: []apiParam{
+			{"q", "query", "Words to look for in the title, author, tags, publisher and ISBN", stringSchema},
+			limitParam, offsetParam,
+		},
+		response: searchPage{},
+		problems: []problemType{probInvalidQuery},
+	},
+	"GET /books/export": {
+		summary: "Stream the whole catalog as NDJSON or CSV", tag: "books", role: roleReader,
+		params: []apiParam{
+			{"format", "query", "ndjson or csv, else the Accept header decides", map[string]any{"type": "string", "enum": []string{"ndjson", "csv"}}},
+		},
+		produces: []string{mimeNDJSON, mimeCSV},
+		problems: []problemType{probInvalidQuery},
+	},
+	"GET /books/{id}": {
+		summary: "Get a book", tag: "books", role: roleReader,
+		params:   []apiParam{ifNoneMatchParam},
+		response: Book{},
+		also:     map[int]any{fiber.StatusNotModified: nil},
+		problems: []problemType{probInvalidID, probBookNotFound},
+	},
+	"GET /books/isbn/{isbn}": {
+		summary: "Find a book by its ISBN-10 or ISBN-13", tag: "books", role: roleReader,
+		params:   []apiParam{ifNoneMatchParam},
+		response: Book{},
+		also:     map[int]any{fiber.StatusNotModified: nil},
+		problems: []problemType{probInvalidISBN, probBookNotFound},
+	},
+	"POST /books": {
+		summary: "Create a book", tag: "books", role: roleEditor,
+		request: Book{}, status: fiber.StatusCreated, response: Book{},
+		problems: []problemType{probMalformedRequest, probUnsupportedMediaType, probValidation, probISBNTaken},
+	},
+	"POST /books/bulk": {
+		summary: "Import many books from CSV or NDJSON", tag: "books", role: roleEditor,
+		params: []apiParam{
+			{"mode", "query", "atomic creates every row or none, best-effort creates the valid rows", map[string]any{"type": "string", "enum": []string{bulkAtomic, bulkBestEffort}, "default": bulkAtomic}},
+		},
+		requestTypes: []string{mimeCSV, mimeNDJSON},
+		status:       fiber.StatusCreated, response: bulkReport{},
+		also:     map[int]any{fiber.StatusOK: bulkReport{}}, // best-effort imports answer 200, whatever was created
+		problems: []problemType{probInvalidQuery, probMalformedRequest, probUnsupportedMediaType, probBulkRejected},
+	},
+	"PUT /books/{id}": {
+		summary: "Replace a book", tag: "books", role: roleEditor,
+		params:  []apiParam{ifMatchParam},
+		request: Book{}, response: Book{},
+		problems: []problemType{probInvalidID, probMalformedRequest, probUnsupportedMediaType, probValidation, probBookNotFound, probISBNTaken, probPreconditionFailed},
+	},
+	"PATCH /books/{id}": {
+		summary: "Change part of a book with a JSON Merge Patch or a JSON Patch", tag: "books", role: roleEditor,
+		params:       []apiParam{ifMatchParam},
+		request:      Book{},
+		requestTypes: []string{mimeMergePatch, mimeJSONPatch},
+		response:     Book{},
+		problems:     []problemType{probInvalidID, probUnsupportedMediaType, probPatchMalformed, probPatchTestFailed, probPatchUnprocessable, probValidation, probBookNotFound, probISBNTaken, probPreconditionFailed},
+	},
+	"DELETE /books/{id}": {
+		summary: "Delete a book", tag: "books", role: roleEditor,
+		params:   []apiParam{ifMatchParam},
+		produces: []string{fiber.MIMETextPlainCharsetUTF8},
+		problems: []problemType{probInvalidID, probBookNotFound, probPreconditionFailed},
+	},
+	"GET /users": {
+		summary: "List the accounts", tag: "users", role: roleAdmin,
+		response: []User{},
+	},
+	"GET /users/{id}": {
+		summary: "Get an account", tag: "users", role: roleAdmin,
+		response: User{},
+		problems: []problemType{probInvalidID, probUserNotFound},
+	},
+	"POST /users": {
+		summary: "Create an account with any role", tag: "users", role: roleAdmin,
+		request: userInput{}, status: fiber.StatusCreated, response: User{},
+		problems: []problemType{probMalformedRequest, probInvalidUser, probUsernameTaken},
+	},
+	"PUT /users/{id}": {
+		summary: "Change the username, password or role of an account, empty fields are kept", tag: "users", role: roleAdmin,
+		request: userInput{}, response: User{},
+		problems: []problemType{probInvalidID, probMalformedRequest, probInvalidUser, probUserNotFound, probUsernameTaken},
+	},
+	"DELETE /users/{id}": {
+		summary: "Delete an account", tag: "users", role: roleAdmin,
+		status:   fiber.StatusNoContent,
+		problems: []problemType{probInvalidID, probInvalidUser, probUserNotFound}, // admins can't delete their own account
+	},
+	"GET /admin/config": {
+		summary: "Effective configuration and where each value came from", tag: "admin", role: roleAdmin,
+		response: configReport{},
+	},
+	"GET /webhooks": {
+		summary: "List the webhooks, without their secrets", tag: "webhooks", role: roleAdmin,
+		response: []Webhook{},
+	},
+	"POST /webhooks": {
+		summary: "Subscribe a URL to book events, the answer has the secret that signs its deliveries", tag: "webhooks", role: roleAdmin,
+		request: webhookInput{}, status: fiber.StatusCreated, response: createdWebhook{},
+		problems: []problemType{probMalformedRequest, probInvalidWebhook},
+	},
+	"GET /webhooks/{id}": {
+		summary: "Get a webhook", tag: "webhooks", role: roleAdmin,
+		response: Webhook{},
+		problems: []problemType{probInvalidID, probWebhookNotFound},
+	},
+	"DELETE /webhooks/{id}": {
+		summary: "Delete a webhook and drop its pending deliveries", tag: "webhooks", role: roleAdmin,
+		status:   fiber.StatusNoContent,
+		problems: []problemType{probInvalidID, probWebhookNotFound},
+	},
+	"GET /webhooks/deliveries": {
+		summary: "Delivery log, newest first, with every attempt", tag: "webhooks", role: roleAdmin,
+		params: []apiParam{
+			{"webhook", "query", "Only deliveries to this webhook", integerSchema},
+			{"event", "query", "Only deliveries of this event", map[string]any{"type": "string", "enum": webhookEvents}},
+			{"status", "query", "Only deliveries in this state", map[string]any{"type": "string", "enum": []string{deliveryPending, deliveryDelivered, deliveryDead}}},
+			limitParam,
+		},
+		response: deliveryLog{},
+		problems: []problemType{probInvalidQuery},
+	},
+	"GET /webhooks/deliveries/{id}": {
+		summary: "Get a delivery with its payload and attempts", tag: "webhooks", role: roleAdmin,
+		response: Delivery{},
+		problems: []problemType{probInvalidID, probDeliveryNotFound},
+	},
+	"POST /webhooks/deliveries/{id}/retry": {
+		summary: "Send a dead delivery again", tag: "webhooks", role: roleAdmin,
+		status: fiber.StatusAccepted, response: Delivery{},
+		problems: []problemType{probInvalidID, probDeliveryNotFound, probWebhookNotFound, probDeliveryNotDead},
+	},
+	"GET /admin/lockouts": {
+		summary: "Locked accounts, and IPs and accounts with failed or used up login attempts", tag: "admin", role: roleAdmin,
+		response: lockoutList{},
+	},
+	"DELETE /admin/lockouts/{kind}/{id}": {
+		summary: "Unlock an account or IP and give it its full login attempts back", tag: "admin", role: roleAdmin,
+		status:   fiber.StatusNoContent,
+		problems: []problemType{probInvalidID, probLockoutNotFound},
+	},
+}
+
+// fiberParam matches the :name parameters of a Fiber path, optionally followed by ? or +
+var fiberParam = regexp.MustCompile(`:([A-Za-z0-9_]+)[?+]?`)
+
+// openAPIPath turns a Fiber path such as /books/:id into the OpenAPI form /books/{id}
+func openAPIPath(path string) string {
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/") // a group's "/" route is registered as /users/
+	}
+	return fiberParam.ReplaceAllString(path, "{$1}")
+}
+
+// buildOpenAPI builds the OpenAPI 3.1 document of the routes and lists the routes apiOps doesn't document
+func buildOpenAPI(routes []fiber.Route) (spec map[string]any, undocumented []string) {
+	schemas := schemaSet{"Problem": problemSchema}
+	schemas.of(reflect.TypeOf(fieldError{})) // the errors member of a Problem
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		if route.Method == fiber.MethodHead {
+			continue // Fiber answers HEAD for every GET route
+		}
+		path := openAPIPath(route.Path)
+		key := route.Method + " " + path
+		op, ok := apiOps[key]
+		if !ok {
+			undocumented = append(undocumented, key)
+			continue
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op.document(route.Method, path, schemas)
+	}
+	sort.Strings(undocumented)
+
+	spec = map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "GoAPI books",
+			"version":     "1.0.0",
+			"description": "Book catalog with JWT authentication. Every error is an RFC 7807 application/problem+json document.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+	return spec, undocumented
+}
+
+// document writes the operation as an OpenAPI operation object
+func (op apiOperation) document(method, path string, schemas schemaSet) map[string]any {
+	doc := map[string]any{
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+		"operationId": operationID(method, path),
+	}
+
+	var params []map[string]any
+	for _, m := range fiberParam.FindAllStringSubmatch(strings.NewReplacer("{", ":", "}", "").Replace(path), -1) {
+		params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": stringSchema})
+	}
+	for _, p := range op.params {
+		params = append(params, map[string]any{"name": p.name, "in": p.in, "description": p.description, "schema": p.schema})
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+
+	if op.request != nil {
+		types := op.requestTypes
+		if len(types) == 0 {
+			types = []string{fiber.MIMEApplicationJSON}
+		}
+		content := map[string]any{}
+		for _, t := range types {
+			switch t {
+			case fiber.MIMEApplicationJSON, mimeMergePatch:
+				content[t] = map[string]any{"schema": schemas.of(reflect.TypeOf(op.request))}
+			case mimeJSONPatch:
+				content[t] = map[string]any{"schema": schemas.of(reflect.TypeOf([]patchOp{}))}
+			default:
+				content[t] = map[string]any{"schema": stringSchema} // a CSV or NDJSON file
+			}
+		}
+		doc["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+
+	status := op.status
+	if status == 0 {
+		status = fiber.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if status != fiber.StatusNoContent {
+		content := map[string]any{}
+		if op.response != nil {
+			content[fiber.MIMEApplicationJSON] = map[string]any{"schema": schemas.of(reflect.TypeOf(op.response))}
+		}
+		for _, t := range op.produces {
+			content[t] = map[string]any{"schema": stringSchema}
+		}
+		if len(content) > 0 {
+			success["content"] = content
+		}
+	}
+	responses := map[string]any{strconv.Itoa(status): success}
+	for code, body := range op.also {
+		also := map[string]any{"description": http.StatusText(code)}
+		if body != nil {
+			also["content"] = map[string]any{fiber.MIMEApplicationJSON: map[string]any{"schema": schemas.of(reflect.TypeOf(body))}}
+		}
+		responses[strconv.Itoa(code)] = also
+	}
+
+	problems := append([]problemType{}, op.problems...)
+	if op.role != "" {
+		doc["security"] = []map[string]any{{"bearerAuth": []string{}}}
+		doc["description"] = "Needs a bearer token with at least the " + op.role + " role."
+		problems = append(problems, probMissingToken, probInvalidToken, probTokenRevoked)
+		if op.role != roleReader {
+			problems = append(problems, probForbidden)
+		}
+	}
+	// group the problem types by status, a response object is per status code
+	byStatus := map[int][]string{}
+	for _, p := range problems {
+		byStatus[p.status] = append(byStatus[p.status], p.uri())
+	}
+	byStatus[fiber.StatusInternalServerError] = []string{probInternal.uri()}
+	for code, uris := range byStatus {
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code) + ": " + strings.Join(uris, ", "),
+			"content": map[string]any{
+				mimeProblem: map[string]any{"schema": schemaRef("Problem")},
+			},
+		}
+	}
+	doc["responses"] = responses
+	return doc
+}
+
+// operationID names an operation after its method and path, e.g. put_books_id
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') }) {
+		id += "_" + part
+	}
+	return id
+}
+
+// problemSchema describes the RFC 7807 body of every error response. Some problems add
+// extension members, e.g. the "errors" list of a 422.
+var problemSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"type":     map[string]any{"type": "string", "format": "uri-reference", "description": "Identifies the problem, clients switch on this"},
+		"title":    stringSchema,
+		"status":   integerSchema,
+		"detail":   stringSchema,
+		"instance": map[string]any{"type": "string", "format": "uri-reference"},
+		"errors":   map[string]any{"type": "array", "items": schemaRef("FieldError"), "description": "Every failing field of a 422"},
+	},
+	"required": []string{"type", "title", "status"},
+}
+
+// schemaSet holds the named schemas of components/schemas
+type schemaSet map[string]any
+
+func schemaRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+var (
+	bookIDType = reflect.TypeOf(BookID(""))
+	timeType   = reflect.TypeOf(time.Time{})
+	rawType    = reflect.TypeOf(json.RawMessage{})
+)
+
+// of returns the schema of a Go type the way encoding/json writes it.
+// Named structs become components and are referenced, so Book is described once.
+func (s schemaSet) of(t reflect.Type) map[string]any {
+	switch t {
+	case bookIDType:
+		if _, ok := s["BookID"]; !ok {
+			s["BookID"] = map[string]any{
+				"description": "A sequential number, a UUID or a ULID, depending on BOOK_ID_TYPE",
+				"oneOf":       []any{integerSchema, stringSchema},
+			}
+		}
+		return schemaRef("BookID")
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawType:
+		return map[string]any{} // any JSON value
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.of(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			s[name] = nil // reserve the name first, a type may refer to itself
+			properties := map[string]any{}
+			var required []string
+			s.addFields(t, properties, &required)
+			schema := map[string]any{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+			s[name] = schema
+		}
+		return schemaRef(name)
+	}
+	panic("openapi: no schema for " + t.String()) // a new field type needs a case above
+}
+
+// addFields adds the JSON members of a struct, embedded structs are flattened like encoding/json does
+func (s schemaSet) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			s.addFields(f.Type, properties, required)
+			continue
+		}
+		if !f.IsExported() || f.Tag.Get("json") == "-" {
+			continue
+		}
+		schema := s.of(f.Type)
+		if _, isRef := schema["$ref"]; !isRef {
+			for _, spec := range strings.Split(f.Tag.Get("validate"), ",") {
+				name, param, _ := strings.Cut(spec, "=")
+				n, _ := strconv.Atoi(param)
+				switch {
+				case name == "required":
+					*required = append(*required, jsonName(f))
+				case name == "min" || name == "max":
+					schema[constraint(name, f.Type.Kind())] = n
+				case name == "year":
+					schema["minimum"] = 1
+				case name == "isbn":
+					schema["description"] = "ISBN-10 or ISBN-13, always stored and returned as an ISBN-13"
+				case name == "language":
+					schema["pattern"] = "^[A-Za-z]{2,3}$"
+				case name == "tags":
+					schema["items"] = map[string]any{"type": "string", "maxLength": maxTagLength}
+				}
+			}
+		}
+		properties[jsonName(f)] = schema
+	}
+}
+
+// constraint is the JSON Schema keyword of a min or max rule on a field of this kind
+func constraint(rule string, kind reflect.Kind) string {
+	suffix := "Length"
+	switch kind {
+	case reflect.Int:
+		if rule == "min" {
+			return "minimum"
+		}
+		return "maximum"
+	case reflect.Slice:
+		suffix = "Items"
+	}
+	return rule + suffix
+}
+
+// schemaName is the component name of a struct, exported style: tokenPair is TokenPair
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		panic("openapi: anonymous struct " + t.String() + " needs a named type")
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// openAPIHandler serves the document of the app's routes at GET /openapi.json.
+// It is built on the first request, when every route has been registered.
+func openAPIHandler(app *fiber.App) fiber.Handler {
+	var once sync.Once
+	var body []byte
+	return func(c *fiber.Ctx) error {
+		once.Do(func() {
+			spec, undocumented := buildOpenAPI(app.GetRoutes(true))
+			for _, route := range undocumented {
+				log.Printf("openapi: %s is not documented in apiOps", route)
+			}
+			var err error
+			if body, err = json.Marshal(spec); err != nil {
+				panic(err) // the spec is made of maps and strings, this can't fail
+			}
+		})
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(body)
+	}
+}
+
+// swaggerPage is the Swagger UI page at GET /docs, it points the UI at /openapi.json
+//
+//go:embed swagger.html
+var swaggerPage []byte
+
+// swaggerUI is the vendored Swagger UI, see swagger-ui/README.md.
+// It is served by this server, so /docs works offline and runs no script from another origin.
+//
+//go:embed swagger-ui/swagger-ui.css swagger-ui/swagger-ui-bundle.js
+var swaggerUI embed.FS
+
+func docsHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Send(swaggerPage)
+}
+
+// docsAsset handles GET /docs/:file, the script and stylesheet of Swagger UI
+func docsAsset(c *fiber.Ctx) error {
+	file := c.Params("file")
+	data, err := swaggerUI.ReadFile("swagger-ui/" + file)
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+	c.Type(path.Ext(file)) // .css or .js
+	c.Set(fiber.HeaderCacheControl, "public, max-age=86400")
+	return c.Send(data)
+}
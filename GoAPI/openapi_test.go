@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	app := newTestApp(t)
+	spec, undocumented := buildOpenAPI(app.GetRoutes(true))
+	for _, route := range undocumented {
+		t.Errorf("%s is registered but has no entry in apiOps", route)
+	}
+
+	// and the other way around: an entry for a route that no longer exists is wrong too
+	paths := spec["paths"].(map[string]map[string]any)
+	for key := range apiOps {
+		method, path, _ := strings.Cut(key, " ")
+		if _, ok := paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("apiOps documents %s, but no such route is registered", key)
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	app := newTestApp(t)
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/openapi.json", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string       `json:"required"`
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("the document is not JSON: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", doc.OpenAPI)
+	}
+	book, ok := doc.Components.Schemas["Book"]
+	if !ok {
+		t.Fatal("no Book schema")
+	}
+	if len(book.Required) != 2 || book.Required[0] != "title" || book.Required[1] != "author" {
+		t.Errorf("Book requires %v, want [title author]", book.Required)
+	}
+	if _, ok := doc.Components.Schemas["User"].Properties["PasswordHash"]; ok {
+		t.Error("the User schema leaks the password hash")
+	}
+}
+
+// TestDocsServedLocally checks that Swagger UI comes from the binary and the page loads nothing from another origin
+func TestDocsServedLocally(t *testing.T) {
+	app := newTestApp(t)
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/docs", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(page), "://") {
+		t.Errorf("GET /docs references another origin:\n%s", page)
+	}
+
+	for file, contentType := range map[string]string{
+		"swagger-ui.css":       "text/css",
+		"swagger-ui-bundle.js": "javascript",
+	} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/docs/"+file, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK || !strings.Contains(resp.Header.Get(fiber.HeaderContentType), contentType) {
+			t.Errorf("GET /docs/%s: status %d, content type %q", file, resp.StatusCode, resp.Header.Get(fiber.HeaderContentType))
+		}
+	}
+
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/docs/swagger.html", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("GET /docs/swagger.html: status %d, want 404", resp.StatusCode)
+	}
+}
+
+// TestOpenAPIResponses checks the status codes of routes that answer with more than one success status
+func TestOpenAPIResponses(t *testing.T) {
+	app := newTestApp(t)
+	spec, _ := buildOpenAPI(app.GetRoutes(true))
+	paths := spec["paths"].(map[string]map[string]any)
+	tests := []struct {
+		method, path string
+		want         []string
+	}{
+		{"get", "/books/{id}", []string{"200", "304", "400", "404"}},
+		{"post", "/books/bulk", []string{"200", "201", "415", "422"}},
+		{"delete", "/users/{id}", []string{"204", "400", "403", "404"}},
+	}
+	for _, tt := range tests {
+		responses := paths[tt.path][tt.method].(map[string]any)["responses"].(map[string]any)
+		for _, code := range tt.want {
+			if _, ok := responses[code]; !ok {
+				t.Errorf("%s %s doesn't document %s", tt.method, tt.path, code)
+			}
+		}
+	}
+	deleted := paths["/users/{id}"]["delete"].(map[string]any)["responses"].(map[string]any)
+	if _, ok := deleted["204"].(map[string]any)["content"]; ok {
+		t.Error("DELETE /users/{id} documents a body for its 204")
+	}
+}
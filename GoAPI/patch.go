@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mimeMergePatch = "application/merge-patch+json" // RFC 7396
+	mimeJSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+// patch errors, the handler maps each of them to a different status code
+var (
+	errPatchMalformed  = errors.New("malformed patch document")    // 400
+	errPatchTestFailed = errors.New("patch test operation failed") // 409
+	errPatchPath       = errors.New("patch path does not exist")   // 422
+	errPatchResult     = errors.New("patched book is invalid")     // 422
+)
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch to doc:
+// objects are merged key by key, null removes a key and anything else replaces the value
+func applyMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", errPatchMalformed, err)
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch // a non-object patch replaces the whole target
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// patchOp is one operation of an RFC 6902 JSON Patch
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to doc.
+// The operations run in order on a copy, if any of them fails nothing is changed.
+func applyJSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	var ops []patchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", errPatchMalformed, err)
+	}
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+		target, err = applyPatchOp(target, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func applyPatchOp(doc any, op patchOp) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", errPatchMalformed)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", errPatchMalformed)
+		}
+		var v any
+		if err := json.Unmarshal(*op.Value, &v); err != nil {
+			return nil, fmt.Errorf("%w: %v", errPatchMalformed, err)
+		}
+		return v, nil
+	}
+	from := func() ([]string, error) {
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", errPatchMalformed)
+		}
+		return parsePointer(*op.From)
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "move":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		if len(src) < len(path) && reflect.DeepEqual(src, path[:len(src)]) {
+			return nil, fmt.Errorf("%w: cannot move a value into one of its children", errPatchMalformed)
+		}
+		v, err := pointerGet(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, src); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "copy":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, deepCopy(v))
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(got, want) {
+			return nil, fmt.Errorf("%w: value at %q does not match", errPatchTestFailed, *op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", errPatchMalformed, op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer such as "/tags/0" into its unescaped tokens
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil // the whole document
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", errPatchMalformed, ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", errPatchPath, token)
+			}
+			doc = v
+		case []any:
+			idx, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[idx]
+		default:
+			return nil, fmt.Errorf("%w: %q", errPatchPath, token)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd sets the value at path, inserting into arrays, and returns the (possibly new) root
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return doc, nil
+	case []any:
+		idx := len(node)
+		if last != "-" {
+			if idx, err = arrayIndex(last, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		grown := append(node[:idx:idx], append([]any{value}, node[idx:]...)...)
+		return pointerReplaceParent(doc, path[:len(path)-1], grown)
+	default:
+		return nil, fmt.Errorf("%w: %q", errPatchPath, last)
+	}
+}
+
+// pointerRemove deletes the value at path and returns the (possibly new) root
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, ok := node[last]; !ok {
+			return nil, fmt.Errorf("%w: %q", errPatchPath, last)
+		}
+		delete(node, last)
+		return doc, nil
+	case []any:
+		idx, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		shrunk := append(node[:idx:idx], node[idx+1:]...)
+		return pointerReplaceParent(doc, path[:len(path)-1], shrunk)
+	default:
+		return nil, fmt.Errorf("%w: %q", errPatchPath, last)
+	}
+}
+
+// pointerReplaceParent stores a resized array back into its parent, slices can't be changed in place
+func pointerReplaceParent(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+	case []any:
+		idx, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node[idx] = value
+	}
+	return doc, nil
+}
+
+// arrayIndex parses an array token and checks it is between 0 and maxIdx
+func arrayIndex(token string, maxIdx int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: bad array index %q", errPatchPath, token)
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > maxIdx {
+		return 0, fmt.Errorf("%w: array index %q out of range", errPatchPath, token)
+	}
+	return idx, nil
+}
+
+func deepCopy(v any) any {
+	data, _ := json.Marshal(v) // v came from json.Unmarshal, so it always marshals
+	var out any
+	_ = json.Unmarshal(data, &out)
+	return out
+}
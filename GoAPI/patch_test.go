@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const patchDoc = `{"title":"Go","tags":["a","b"],"meta":{"x":1}}`
+
+// sameJSON compares two documents regardless of key order
+func sameJSON(t *testing.T, got []byte, want string) bool {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("result %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("want %s: %v", want, err)
+	}
+	gb, _ := json.Marshal(g)
+	wb, _ := json.Marshal(w)
+	return string(gb) == string(wb)
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name, patch, want string
+	}{
+		{"replace a value", `{"title":"Rust"}`, `{"title":"Rust","tags":["a","b"],"meta":{"x":1}}`},
+		{"null removes", `{"meta":null}`, `{"title":"Go","tags":["a","b"]}`},
+		{"objects merge", `{"meta":{"y":2}}`, `{"title":"Go","tags":["a","b"],"meta":{"x":1,"y":2}}`},
+		{"arrays are replaced", `{"tags":["c"]}`, `{"title":"Go","tags":["c"],"meta":{"x":1}}`},
+		{"null removes nested", `{"meta":{"x":null}}`, `{"title":"Go","tags":["a","b"],"meta":{}}`},
+		{"empty patch", `{}`, patchDoc},
+	}
+	for _, tt := range tests {
+		got, err := applyMergePatch([]byte(patchDoc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameJSON(t, got, tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if _, err := applyMergePatch([]byte(patchDoc), []byte(`{"title":`)); !errors.Is(err, errPatchMalformed) {
+		t.Errorf("malformed merge patch: %v", err)
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    string // the patched document, or
+		wantErr error  // the error and
+		status  int    // the status it is answered with
+	}{
+		{name: "add field", patch: `[{"op":"add","path":"/year","value":2015}]`, want: `{"title":"Go","tags":["a","b"],"meta":{"x":1},"year":2015}`},
+		{name: "add into array", patch: `[{"op":"add","path":"/tags/1","value":"z"}]`, want: `{"title":"Go","tags":["a","z","b"],"meta":{"x":1}}`},
+		{name: "append to array", patch: `[{"op":"add","path":"/tags/-","value":"z"}]`, want: `{"title":"Go","tags":["a","b","z"],"meta":{"x":1}}`},
+		{name: "remove", patch: `[{"op":"remove","path":"/tags/0"}]`, want: `{"title":"Go","tags":["b"],"meta":{"x":1}}`},
+		{name: "replace", patch: `[{"op":"replace","path":"/title","value":"Rust"}]`, want: `{"title":"Rust","tags":["a","b"],"meta":{"x":1}}`},
+		{name: "move", patch: `[{"op":"move","from":"/meta/x","path":"/pages"}]`, want: `{"title":"Go","tags":["a","b"],"meta":{},"pages":1}`},
+		{name: "copy", patch: `[{"op":"copy","from":"/tags","path":"/labels"}]`, want: `{"title":"Go","tags":["a","b"],"labels":["a","b"],"meta":{"x":1}}`},
+		{name: "test then replace", patch: `[{"op":"test","path":"/title","value":"Go"},{"op":"replace","path":"/title","value":"Rust"}]`, want: `{"title":"Rust","tags":["a","b"],"meta":{"x":1}}`},
+		{name: "escaped pointer", patch: `[{"op":"add","path":"/a~1b~0c","value":1}]`, want: `{"title":"Go","tags":["a","b"],"meta":{"x":1},"a/b~c":1}`},
+
+		{name: "not a list", patch: `{"op":"add"}`, wantErr: errPatchMalformed, status: fiber.StatusBadRequest},
+		{name: "unknown op", patch: `[{"op":"merge","path":"/title"}]`, wantErr: errPatchMalformed, status: fiber.StatusBadRequest},
+		{name: "missing path", patch: `[{"op":"remove"}]`, wantErr: errPatchMalformed, status: fiber.StatusBadRequest},
+		{name: "missing value", patch: `[{"op":"add","path":"/year"}]`, wantErr: errPatchMalformed, status: fiber.StatusBadRequest},
+		{name: "missing from", patch: `[{"op":"copy","path":"/year"}]`, wantErr: errPatchMalformed, status: fiber.StatusBadRequest},
+		{name: "relative pointer", patch: `[{"op":"remove","path":"title"}]`, wantErr: errPatchMalformed, status: fiber.StatusBadRequest},
+		{name: "move into own child", patch: `[{"op":"move","from":"/meta","path":"/meta/inner"}]`, wantErr: errPatchMalformed, status: fiber.StatusBadRequest},
+		{name: "test fails", patch: `[{"op":"test","path":"/title","value":"Rust"}]`, wantErr: errPatchTestFailed, status: fiber.StatusConflict},
+		{name: "remove missing", patch: `[{"op":"remove","path":"/year"}]`, wantErr: errPatchPath, status: fiber.StatusUnprocessableEntity},
+		{name: "index out of range", patch: `[{"op":"replace","path":"/tags/5","value":"z"}]`, wantErr: errPatchPath, status: fiber.StatusUnprocessableEntity},
+		{name: "leading zero index", patch: `[{"op":"remove","path":"/tags/01"}]`, wantErr: errPatchPath, status: fiber.StatusUnprocessableEntity},
+		{name: "missing parent", patch: `[{"op":"add","path":"/nothing/here","value":1}]`, wantErr: errPatchPath, status: fiber.StatusUnprocessableEntity},
+		{name: "later op fails", patch: `[{"op":"replace","path":"/title","value":"Rust"},{"op":"remove","path":"/year"}]`, wantErr: errPatchPath, status: fiber.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		got, err := applyJSONPatch([]byte(patchDoc), []byte(tt.patch))
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+				continue
+			}
+			if p := asProblem(context.Background(), err); p.Status != tt.status {
+				t.Errorf("%s: answered with %d, want %d", tt.name, p.Status, tt.status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameJSON(t, got, tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
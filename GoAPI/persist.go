@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/health"
+)
+
+const (
+	journalFile  = "books.journal.jsonl"
+	snapshotFile = "books.snapshot.json"
+)
+
+// journalEntry is one line of the append-only journal.
+// Seq increases by one for every change so replay can skip entries already in the snapshot.
+type journalEntry struct {
+	Seq uint64 `json:"seq"`
+	bookChange
+}
+
+// snapshot is the compacted state of the store at journal position Seq.
+// LastID keeps the ID allocator ahead of books that were deleted before the snapshot.
+type snapshot struct {
+	Seq    uint64 `json:"seq"`
+	LastID BookID `json:"last_id,omitempty"`
+	Books  []Book `json:"books"`
+}
+
+// fileBookStore is a memoryBookStore that survives restarts.
+// Every change is appended to a JSON-lines journal (and fsynced) before it is applied in memory,
+// and every snapshotEvery changes the whole catalog is written to a snapshot and the journal is truncated.
+type fileBookStore struct {
+	*memoryBookStore
+
+	dir           string
+	snapshotEvery int
+
+	writeMu sync.Mutex // serializes writers so a snapshot always matches the journal position
+	journal *os.File
+	seq     uint64 // sequence number of the last journaled change
+	pending int    // changes journaled since the last snapshot
+	fresh   bool   // true if no data existed when the store was opened
+}
+
+// openFileBookStore loads the snapshot and replays the journal found in dir.
+// A half-written last journal line (e.g. after a crash) is dropped and truncated away.
+func openFileBookStore(dir string, snapshotEvery int, ids idAllocator) (*fileBookStore, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = 100
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create data directory: %w", err)
+	}
+
+	s := &fileBookStore{
+		memoryBookStore: newMemoryBookStore(ids),
+		dir:             dir,
+		snapshotEvery:   snapshotEvery,
+	}
+
+	snapFound, err := s.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	journalFound, err := s.replayJournal()
+	if err != nil {
+		return nil, err
+	}
+	s.fresh = !snapFound && !journalFound
+
+	s.journal, err = os.OpenFile(filepath.Join(dir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal: %w", err)
+	}
+
+	s.addHook(s.appendJournal)
+	return s, nil
+}
+
+func (s *fileBookStore) Create(book Book) (Book, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	created, err := s.memoryBookStore.Create(book)
+	if err != nil {
+		return Book{}, err
+	}
+	return created, s.maybeSnapshot()
+}
+
+func (s *fileBookStore) CreateMany(books []Book) ([]Book, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	created, err := s.memoryBookStore.CreateMany(books)
+	if err != nil {
+		return nil, err
+	}
+	return created, s.maybeSnapshot()
+}
+
+func (s *fileBookStore) Update(id BookID, fn func(book *Book) error) (Book, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	updated, err := s.memoryBookStore.Update(id, fn)
+	if err != nil {
+		return Book{}, err
+	}
+	return updated, s.maybeSnapshot()
+}
+
+func (s *fileBookStore) Delete(id BookID, check func(book Book) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.memoryBookStore.Delete(id, check); err != nil {
+		return err
+	}
+	return s.maybeSnapshot()
+}
+
+// Snapshot compacts the journal into a snapshot right away
+func (s *fileBookStore) Snapshot() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.snapshot()
+}
+
+// Close writes a final snapshot and closes the journal
+func (s *fileBookStore) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	err := s.snapshot()
+	if cerr := s.journal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Check is the readiness check of the store: the journal is still open
+// and the data directory takes the new files a snapshot is written to
+func (s *fileBookStore) Check(ctx context.Context) error {
+	s.writeMu.Lock()
+	_, err := s.journal.Stat()
+	s.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return health.Writable(s.dir)(ctx)
+}
+
+// appendJournal is the change hook that makes every change durable before it is applied
+func (s *fileBookStore) appendJournal(change bookChange) error {
+	line, err := json.Marshal(journalEntry{Seq: s.seq + 1, bookChange: change})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := s.journal.Write(line); err != nil {
+		return fmt.Errorf("could not write journal: %w", err)
+	}
+	if err := s.journal.Sync(); err != nil {
+		return fmt.Errorf("could not sync journal: %w", err)
+	}
+	s.seq++
+	s.pending++
+	return nil
+}
+
+// maybeSnapshot compacts once enough changes piled up. Callers must hold s.writeMu.
+func (s *fileBookStore) maybeSnapshot() error {
+	if s.pending < s.snapshotEvery {
+		return nil
+	}
+	if err := s.snapshot(); err != nil {
+		// the change itself is safe in the journal, so only report the failed compaction
+		log.Printf("book store: snapshot failed: %v", err)
+	}
+	return nil
+}
+
+// snapshot writes the current state atomically (temp file + rename) and then empties the journal.
+// If we crash in between, replay skips the journal entries already covered by the snapshot's seq.
+// Callers must hold s.writeMu.
+func (s *fileBookStore) snapshot() error {
+	data, err := json.Marshal(snapshot{Seq: s.seq, LastID: s.ids.Last(), Books: s.List()})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(s.dir, snapshotFile, data); err != nil {
+		return err
+	}
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	s.pending = 0
+	return nil
+}
+
+// writeFileAtomic replaces dir/name with data through a synced temp file and a rename,
+// so a crash leaves either the old or the new file, never half of one
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// loadSnapshot reads the snapshot file if there is one
+func (s *fileBookStore) loadSnapshot() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return false, fmt.Errorf("could not decode snapshot: %w", err)
+	}
+	s.books = snap.Books
+	s.seq = snap.Seq
+	s.ids.Observe(snap.LastID)
+	for _, book := range snap.Books {
+		s.ids.Observe(book.ID)
+	}
+	return true, nil
+}
+
+// replayJournal applies every journal entry newer than the snapshot
+func (s *fileBookStore) replayJournal() (bool, error) {
+	path := filepath.Join(s.dir, journalFile)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not open journal: %w", err)
+	}
+	defer f.Close()
+
+	err = readJournal(f, func(line []byte) error {
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		if entry.Seq <= s.seq {
+			return nil // already part of the snapshot
+		}
+		s.apply(entry.bookChange)
+		for _, book := range entry.affected() {
+			s.ids.Observe(book.ID) // deletes count too, their IDs must not come back
+		}
+		s.seq = entry.Seq
+		s.pending++
+		return nil
+	})
+	return true, err
+}
+
+// readJournal hands every line of a JSON-lines journal, opened for reading and writing, to decode.
+// Only the very last line may fail to decode: it was cut off by a crash mid-write, so it is dropped
+// and truncated away. A broken line anywhere else means the journal is corrupt.
+func readJournal(f *os.File, decode func(line []byte) error) error {
+	reader := bufio.NewReader(f)
+	var good int64 // offset right after the last complete entry
+	var lineNo int
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) == 0 && readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("could not read journal: %w", readErr)
+		}
+		lineNo++
+
+		complete := readErr == nil // a line without '\n' was cut off mid-write
+		if complete && decode(bytes.TrimSpace(line)) != nil {
+			complete = false
+		}
+		if !complete {
+			if _, err := reader.Peek(1); err != io.EOF {
+				return fmt.Errorf("%s is corrupt at line %d", filepath.Base(f.Name()), lineNo)
+			}
+			log.Printf("%s: dropping half-written entry at line %d", filepath.Base(f.Name()), lineNo)
+			if err := f.Truncate(good); err != nil {
+				return fmt.Errorf("could not truncate journal: %w", err)
+			}
+			return nil
+		}
+		good += int64(len(line))
+	}
+}
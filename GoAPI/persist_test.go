@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestFileStore opens a file store with sequential IDs in dir, seeding it like openBookStore
+func openTestFileStore(t *testing.T, dir string, snapshotEvery int) *fileBookStore {
+	t.Helper()
+	ids, err := newIDAllocator("sequential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openFileBookStore(dir, snapshotEvery, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.fresh {
+		if err := seedBooks(store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// crash leaves the store without a last snapshot, the way a killed process does
+func crash(t *testing.T, store *fileBookStore) {
+	t.Helper()
+	if err := store.journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendToJournal(t *testing.T, dir, data string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileBookStoreDropsHalfWrittenEntry(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestFileStore(t, dir, 100)
+	created, err := store.Create(Book{Title: "Journaled", Author: "Gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, store)
+	journal := filepath.Join(dir, journalFile)
+	before, _ := os.Stat(journal)
+	appendToJournal(t, dir, `{"seq":5,"op":"create","book":{"id":5,"ti`) // cut off mid-write
+
+	reopened := openTestFileStore(t, dir, 100)
+	defer reopened.Close()
+	if got := len(reopened.List()); got != len(sampleBooks())+1 {
+		t.Errorf("%d books after the restart, want %d", got, len(sampleBooks())+1)
+	}
+	if _, err := reopened.Get(created.ID); err != nil {
+		t.Errorf("book %s of the last complete entry: %v", created.ID, err)
+	}
+	if after, _ := os.Stat(journal); after.Size() != before.Size() {
+		t.Errorf("journal is %d bytes, want the %d before the broken entry", after.Size(), before.Size())
+	}
+
+	// the next change goes on a clean line and survives another restart
+	next, err := reopened.Create(Book{Title: "After the crash", Author: "Gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, reopened)
+	again := openTestFileStore(t, dir, 100)
+	defer again.Close()
+	if _, err := again.Get(next.ID); err != nil {
+		t.Errorf("book created after the recovery: %v", err)
+	}
+}
+
+func TestFileBookStoreRefusesCorruptJournal(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestFileStore(t, dir, 100)
+	crash(t, store)
+	// a broken line with complete entries after it wasn't cut off by a crash, replay must not skip it
+	appendToJournal(t, dir, "not json\n"+`{"seq":4,"op":"delete","book":{"id":1}}`+"\n")
+
+	ids, _ := newIDAllocator("sequential")
+	_, err := openFileBookStore(dir, 100, ids)
+	if err == nil || !strings.Contains(err.Error(), "corrupt at line 4") {
+		t.Fatalf("open = %v, want the corrupt line reported", err)
+	}
+}
+
+func TestFileBookStoreSnapshotKeepsIDs(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestFileStore(t, dir, 2) // snapshots while seeding already
+	last, err := store.Create(Book{Title: "Deleted", Author: "Gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(last.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil { // a last snapshot, the journal is empty
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, journalFile)); info.Size() != 0 {
+		t.Errorf("journal has %d bytes after closing, want it compacted", info.Size())
+	}
+
+	reopened := openTestFileStore(t, dir, 2)
+	defer reopened.Close()
+	if reopened.fresh {
+		t.Fatal("store seeded again after a restart")
+	}
+	if got := len(reopened.List()); got != len(sampleBooks()) {
+		t.Errorf("%d books after the restart, want %d", got, len(sampleBooks()))
+	}
+	book, err := reopened.Create(Book{Title: "Next", Author: "Gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.ID.Less(book.ID) {
+		t.Errorf("new book got ID %s, want one after %s of the deleted book", book.ID, last.ID)
+	}
+}
+
+// TestFileBookStoreSnapshotCrash crashes between writing the snapshot and emptying the journal:
+// replay must skip the entries the snapshot already has instead of applying them twice
+func TestFileBookStoreSnapshotCrash(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestFileStore(t, dir, 100)
+	created, err := store.Create(Book{Title: "Twice?", Author: "Gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Update(created.ID, func(book *Book) error {
+		book.Pages = 10
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, store)
+	if err := os.WriteFile(filepath.Join(dir, journalFile), journal, 0o644); err != nil { // Truncate(0) never happened
+		t.Fatal(err)
+	}
+
+	reopened := openTestFileStore(t, dir, 100)
+	defer reopened.Close()
+	if got := len(reopened.List()); got != len(sampleBooks())+1 {
+		t.Errorf("%d books after the restart, want %d", got, len(sampleBooks())+1)
+	}
+	book, err := reopened.Get(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Pages != 10 || book.Version != 2 {
+		t.Errorf("book %+v, want pages 10 at version 2", book)
+	}
+	if reopened.seq != store.seq || reopened.pending != 0 {
+		t.Errorf("at seq %d with %d pending entries, want seq %d and none pending", reopened.seq, reopened.pending, store.seq)
+	}
+}
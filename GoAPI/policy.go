@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// roleRank orders the roles, every role can do everything the roles below it can
+var roleRank = map[string]int{
+	roleReader: 1,
+	roleEditor: 2,
+	roleAdmin:  3,
+}
+
+// machine-readable reasons sent with a 403, so clients can tell the cases apart
+const (
+	denyMissingRole      = "missing_role"      // the token carries no (known) role
+	denyInsufficientRole = "insufficient_role" // the role is known but too low for the route
+)
+
+// requireRole is the per-route policy: only tokens whose role is at least minRole get through.
+// Use it in the route definition, e.g. app.Post("/books", requireRole(roleEditor), handler)
+func requireRole(minRole string) fiber.Handler {
+	if _, ok := roleRank[minRole]; !ok {
+		panic("requireRole: unknown role " + minRole) // a typo in a route table should fail at startup
+	}
+	return func(c *fiber.Ctx) error {
+		role, _ := tokenClaims(c)["role"].(string)
+		rank, known := roleRank[role]
+
+		var reason string
+		switch {
+		case !known:
+			reason = denyMissingRole
+		case rank < roleRank[minRole]:
+			reason = denyInsufficientRole
+		default:
+			return c.Next()
+		}
+
+		// a 403 problem with the reason and the roles as extension members
+		denied := probForbidden.with("This route needs the " + minRole + " role")
+		denied.Extensions = map[string]any{"reason": reason, "required_role": minRole}
+		if role != "" {
+			denied.Extensions["role"] = role
+		}
+		return denied
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// mimeProblem is the content type of every error response (RFC 7807)
+const mimeProblem = "application/problem+json"
+
+// problemType is one kind of failure. Clients switch on its type URI, never on the detail text.
+type problemType struct {
+	slug   string
+	title  string
+	status int
+}
+
+// every failure the API reports, see the table in the README
+var (
+	probMalformedRequest     = problemType{"malformed-request", "Malformed request body", fiber.StatusBadRequest}
+	probInvalidID            = problemType{"invalid-id", "Invalid ID", fiber.StatusBadRequest}
+	probInvalidQuery         = problemType{"invalid-query", "Invalid query parameter", fiber.StatusBadRequest}
+	probInvalidISBN          = problemType{"invalid-isbn", "Invalid ISBN", fiber.StatusBadRequest}
+	probBookNotFound         = problemType{"book-not-found", "Book not found", fiber.StatusNotFound}
+	probISBNTaken            = problemType{"isbn-taken", "ISBN is already taken", fiber.StatusConflict}
+	probPreconditionFailed   = problemType{"precondition-failed", "Book was changed by someone else", fiber.StatusPreconditionFailed}
+	probUnsupportedMediaType = problemType{"unsupported-media-type", "Unsupported media type", fiber.StatusUnsupportedMediaType}
+	probPatchMalformed       = problemType{"malformed-patch", "Malformed patch document", fiber.StatusBadRequest}
+	probPatchTestFailed      = problemType{"patch-test-failed", "Patch test operation failed", fiber.StatusConflict}
+	probPatchUnprocessable   = problemType{"unprocessable-patch", "Patch cannot be applied to the book", fiber.StatusUnprocessableEntity}
+	probValidation           = problemType{"validation-failed", "Some fields are invalid", fiber.StatusUnprocessableEntity}
+	probBulkRejected         = problemType{"bulk-rejected", "Import rejected", fiber.StatusUnprocessableEntity}
+	probUserNotFound         = problemType{"user-not-found", "User not found", fiber.StatusNotFound}
+	probUsernameTaken        = problemType{"username-taken", "Username is already taken", fiber.StatusConflict}
+	probInvalidUser          = problemType{"invalid-user", "Invalid user data", fiber.StatusBadRequest}
+	probBadCredentials       = problemType{"bad-credentials", "Invalid username or password", fiber.StatusUnauthorized}
+	probMissingToken         = problemType{"missing-token", "Missing or malformed access token", fiber.StatusUnauthorized}
+	probInvalidToken         = problemType{"invalid-token", "Invalid or expired access token", fiber.StatusUnauthorized}
+	probTokenRevoked         = problemType{"token-revoked", "Token has been revoked", fiber.StatusUnauthorized}
+	probInvalidRefreshToken  = problemType{"invalid-refresh-token", "Invalid or expired refresh token", fiber.StatusUnauthorized}
+	probForbidden            = problemType{"forbidden", "Forbidden", fiber.StatusForbidden}
+	probTooManyAttempts      = problemType{"too-many-attempts", "Too many login attempts", fiber.StatusTooManyRequests}
+	probLockoutNotFound      = problemType{"lockout-not-found", "No limits recorded for this IP or account", fiber.StatusNotFound}
+	probInvalidWebhook       = problemType{"invalid-webhook", "Invalid webhook", fiber.StatusBadRequest}
+	probWebhookNotFound      = problemType{"webhook-not-found", "Webhook not found", fiber.StatusNotFound}
+	probDeliveryNotFound     = problemType{"delivery-not-found", "Delivery not found", fiber.StatusNotFound}
+	probDeliveryNotDead      = problemType{"delivery-not-dead", "Only dead deliveries can be retried", fiber.StatusConflict}
+	probInternal             = problemType{"internal", "Internal server error", fiber.StatusInternalServerError}
+)
+
+// uri identifies the problem type, relative to the API
+func (t problemType) uri() string {
+	return "/problems/" + t.slug
+}
+
+// with creates a problem of this type with a human-readable detail
+func (t problemType) with(detail string) *problem {
+	return &problem{Type: t.uri(), Title: t.title, Status: t.status, Detail: detail}
+}
+
+// wrap creates a problem of this type that explains err
+func (t problemType) wrap(err error) *problem {
+	p := t.with(err.Error())
+	p.cause = err
+	return p
+}
+
+// problem is an RFC 7807 error response. Handlers return it as an error and errorHandler renders it.
+type problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// Extensions are extra members next to the standard ones, e.g. the reason of a 403
+	Extensions map[string]any
+
+	cause error
+}
+
+func (p *problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+func (p *problem) Unwrap() error { return p.cause }
+
+// MarshalJSON writes the standard members and the extensions as one flat object
+func (p *problem) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		out[k] = v
+	}
+	out["type"] = p.Type
+	out["title"] = p.Title
+	out["status"] = p.Status
+	if p.Detail != "" {
+		out["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		out["instance"] = p.Instance
+	}
+	return json.Marshal(out)
+}
+
+// domainProblems maps the errors of the stores and helpers to the problem they are reported as.
+// Handlers can simply return these errors, wrapped or not.
+var domainProblems = []struct {
+	err error
+	typ problemType
+}{
+	{ErrBookNotFound, probBookNotFound},
+	{errInvalidID, probInvalidID},
+	{errInvalidISBN, probInvalidISBN},
+	{ErrISBNTaken, probISBNTaken},
+	{errPreconditionFailed, probPreconditionFailed},
+	{errPatchMalformed, probPatchMalformed},
+	{errPatchTestFailed, probPatchTestFailed},
+	{errPatchPath, probPatchUnprocessable},
+	{errPatchResult, probPatchUnprocessable},
+	{ErrUserNotFound, probUserNotFound},
+	{ErrUsernameTaken, probUsernameTaken},
+	{errBadCredentials, probBadCredentials},
+	{errInvalidRefreshToken, probInvalidRefreshToken},
+	{ErrWebhookNotFound, probWebhookNotFound},
+	{ErrDeliveryNotFound, probDeliveryNotFound},
+	{errDeliveryNotDead, probDeliveryNotDead},
+}
+
+// asProblem turns any error returned by a handler into a problem
+func asProblem(ctx context.Context, err error) *problem {
+	var p *problem
+	if errors.As(err, &p) {
+		return p
+	}
+	// a 422 listing every failing field
+	var invalid validationErrors
+	if errors.As(err, &invalid) {
+		p := probValidation.wrap(err)
+		p.Extensions = map[string]any{"errors": invalid}
+		return p
+	}
+	for _, m := range domainProblems {
+		if errors.Is(err, m.err) {
+			return m.typ.wrap(err)
+		}
+	}
+	// errors raised by Fiber itself, e.g. 404 for an unknown route or 405
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return &problem{Type: "about:blank", Title: utils.StatusMessage(fe.Code), Status: fe.Code, Detail: fe.Message}
+	}
+	// anything else is a bug or an I/O failure: log it with the request ID, but don't leak it to the client
+	slog.ErrorContext(ctx, "internal error", "error", err)
+	return probInternal.with("")
+}
+
+// errorHandler is the central Fiber ErrorHandler, every failed request ends up here
+func errorHandler(c *fiber.Ctx, err error) error {
+	p := asProblem(c.UserContext(), err)
+	if p.Instance == "" {
+		p.Instance = c.OriginalURL() // the request that failed
+	}
+	return c.Status(p.Status).JSON(p, mimeProblem)
+}
+
+// missingToken answers a request to a protected route that has no Bearer token
+func missingToken(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+	return probMissingToken.with("Send the access token in the Authorization header as a Bearer token")
+}
+
+// jwtError reports jwtware failures in the same format. Requests without a token never get
+// this far, see newJWTMiddleware, so every failure here is a token that doesn't verify.
+func jwtError(c *fiber.Ctx, err error) error {
+	c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+	return probInvalidToken.wrap(err)
+}
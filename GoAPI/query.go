@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// sortKey is one field of the ?sort= parameter, e.g. "-author" is {field: "author", desc: true}
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// bookQuery holds the filters, sort order and page window of a GET /books request
+type bookQuery struct {
+	author string
+	title  string
+	terms  []string // the words of ?q=
+	sort   []sortKey
+	limit  int
+	offset int
+}
+
+// pageCursor is what hides behind the opaque ?cursor= value
+type pageCursor struct {
+	Offset int `json:"o"`
+}
+
+// bookPage is the JSON envelope returned by GET /books
+type bookPage struct {
+	Data  []Book    `json:"data"`
+	Meta  pageMeta  `json:"meta"`
+	Links pageLinks `json:"links"`
+}
+
+type pageMeta struct {
+	Total  int `json:"total"`  // books matching the filters
+	Count  int `json:"count"`  // books in this page
+	Limit  int `json:"limit"`  // page size
+	Offset int `json:"offset"` // position of the first book of this page
+}
+
+type pageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+var sortableFields = map[string]func(a, b Book) int{
+	"id": func(a, b Book) int {
+		switch {
+		case a.ID.Less(b.ID):
+			return -1
+		case b.ID.Less(a.ID):
+			return 1
+		}
+		return 0
+	},
+	"title": func(a, b Book) int {
+		return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	},
+	"author": func(a, b Book) int {
+		return strings.Compare(strings.ToLower(a.Author), strings.ToLower(b.Author))
+	},
+}
+
+// parseBookQuery reads ?author=&title=&q=&sort=&limit=&offset=&cursor= from the request
+func parseBookQuery(c *fiber.Ctx) (bookQuery, error) {
+	q := bookQuery{
+		author: strings.ToLower(strings.TrimSpace(c.Query("author"))),
+		title:  strings.ToLower(strings.TrimSpace(c.Query("title"))),
+		terms:  strings.Fields(strings.ToLower(c.Query("q"))),
+		limit:  defaultPageSize,
+	}
+
+	if raw := c.Query("sort"); raw != "" {
+		for _, field := range strings.Split(raw, ",") {
+			key := sortKey{field: strings.TrimSpace(field)}
+			if strings.HasPrefix(key.field, "-") {
+				key.field, key.desc = key.field[1:], true
+			}
+			if _, ok := sortableFields[key.field]; !ok {
+				return q, fmt.Errorf("cannot sort by %q, use id, title or author", key.field)
+			}
+			q.sort = append(q.sort, key)
+		}
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.limit = n
+	}
+
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return q, errors.New("offset must be a non-negative number")
+		}
+		q.offset = n
+	}
+
+	// a cursor wins over a plain offset, it is what the next/prev links carry
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeCursor(raw)
+		if err != nil {
+			return q, errors.New("invalid cursor")
+		}
+		q.offset = cur.Offset
+	}
+	return q, nil
+}
+
+// matches reports whether the book passes every filter
+func (q bookQuery) matches(book Book) bool {
+	title := strings.ToLower(book.Title)
+	author := strings.ToLower(book.Author)
+
+	if q.author != "" && !strings.Contains(author, q.author) {
+		return false
+	}
+	if q.title != "" && !strings.Contains(title, q.title) {
+		return false
+	}
+	for _, term := range q.terms {
+		if !strings.Contains(title, term) && !strings.Contains(author, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply filters and sorts the books and cuts out the requested page.
+// It also returns how many books matched in total.
+func (q bookQuery) apply(books []Book) ([]Book, int) {
+	matched := books[:0:0]
+	for _, book := range books {
+		if q.matches(book) {
+			matched = append(matched, book)
+		}
+	}
+
+	// always finish with the ID so the order is stable between pages
+	keys := append(append([]sortKey{}, q.sort...), sortKey{field: "id"})
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, key := range keys {
+			cmp := sortableFields[key.field](matched[i], matched[j])
+			if key.desc {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+
+	total := len(matched)
+	start := min(q.offset, total)
+	end := min(start+q.limit, total)
+	return matched[start:end], total
+}
+
+// links builds the self/next/prev URLs, keeping every other query parameter as it was
+func (q bookQuery) links(c *fiber.Ctx, total int) pageLinks {
+	pageURL := func(offset int) string {
+		values := url.Values{}
+		c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+			values.Add(string(key), string(value))
+		})
+		values.Del("offset")
+		values.Set("cursor", encodeCursor(pageCursor{Offset: offset}))
+		return c.BaseURL() + c.Path() + "?" + values.Encode()
+	}
+
+	links := pageLinks{Self: c.BaseURL() + c.OriginalURL()}
+	if q.offset+q.limit < total {
+		links.Next = pageURL(q.offset + q.limit)
+	}
+	if q.offset > 0 {
+		links.Prev = pageURL(max(q.offset-q.limit, 0))
+	}
+	return links
+}
+
+// linkHeader renders the links in RFC 8288 format for the Link response header
+func (l pageLinks) linkHeader() string {
+	var parts []string
+	if l.Next != "" {
+		parts = append(parts, fmt.Sprintf(`<%s>; rel="next"`, l.Next))
+	}
+	if l.Prev != "" {
+		parts = append(parts, fmt.Sprintf(`<%s>; rel="prev"`, l.Prev))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func encodeCursor(cur pageCursor) string {
+	data, _ := json.Marshal(cur) // a struct of ints always marshals
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (pageCursor, error) {
+	var cur pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cur, err
+	}
+	if err := json.Unmarshal(data, &cur); err != nil {
+		return cur, err
+	}
+	if cur.Offset < 0 {
+		return cur, errors.New("negative offset")
+	}
+	return cur, nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// BM25 parameters: k1 limits how much repeating a word helps, b how much long documents are penalized
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+const (
+	prefixWeight   = 0.7 // a prefix match ("prog" finds "programming") counts less than the exact word
+	minPrefixLen   = 2   // shorter query words only match exactly
+	maxExpansions  = 50  // prefix matches considered per query word
+	snippetContext = 40  // characters kept around the first match of a long field
+)
+
+// searchFields are the book fields in the index and how much a match in each counts
+var searchFields = []struct {
+	name   string
+	weight float64
+	value  func(Book) string
+}{
+	{"title", 3, func(b Book) string { return b.Title }},
+	{"author", 2, func(b Book) string { return b.Author }},
+	{"tags", 1.5, func(b Book) string { return strings.Join(b.Tags, " ") }},
+	{"publisher", 1, func(b Book) string { return b.Publisher }},
+	{"isbn", 1, func(b Book) string { return b.ISBN }},
+}
+
+// searchDoc is what the index keeps about one book
+type searchDoc struct {
+	book   Book
+	terms  map[string]float64 // folded term -> field-weighted frequency
+	length float64            // field-weighted number of terms
+}
+
+// searchIndex is an inverted index over the catalog. It is a store observer registered with subscribe,
+// told about every change under the store lock once the journal has it, so it is never out of step with the store.
+type searchIndex struct {
+	mu       sync.RWMutex
+	docs     map[BookID]*searchDoc
+	postings map[string]map[BookID]bool // term -> books containing it
+	terms    []string                   // every term, sorted, for prefix lookups
+	totalLen float64
+}
+
+// newSearchIndex builds the index from the books in the store and keeps it up to date
+func newSearchIndex(store BookStore) *searchIndex {
+	idx := &searchIndex{docs: map[BookID]*searchDoc{}, postings: map[string]map[BookID]bool{}}
+	store.subscribe(idx.load, idx.onChange)
+	return idx
+}
+
+func (idx *searchIndex) load(books []Book) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, book := range books {
+		idx.addLocked(book)
+	}
+}
+
+// onChange is the store observer that indexes every change
+func (idx *searchIndex) onChange(change bookChange) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, book := range change.affected() {
+		idx.removeLocked(book.ID) // an update replaces the old terms
+		if change.Op != opDelete {
+			idx.addLocked(book)
+		}
+	}
+}
+
+func (idx *searchIndex) addLocked(book Book) {
+	doc := &searchDoc{book: book, terms: map[string]float64{}}
+	for _, field := range searchFields {
+		for _, tok := range tokenize(field.value(book)) {
+			doc.terms[tok.term] += field.weight
+			doc.length += field.weight
+		}
+	}
+	for term := range doc.terms {
+		books, ok := idx.postings[term]
+		if !ok {
+			books = map[BookID]bool{}
+			idx.postings[term] = books
+			idx.insertTermLocked(term)
+		}
+		books[book.ID] = true
+	}
+	idx.docs[book.ID] = doc
+	idx.totalLen += doc.length
+}
+
+func (idx *searchIndex) removeLocked(id BookID) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+			idx.deleteTermLocked(term)
+		}
+	}
+	delete(idx.docs, id)
+	idx.totalLen -= doc.length
+}
+
+func (idx *searchIndex) insertTermLocked(term string) {
+	i := sort.SearchStrings(idx.terms, term)
+	idx.terms = append(idx.terms, "")
+	copy(idx.terms[i+1:], idx.terms[i:])
+	idx.terms[i] = term
+}
+
+func (idx *searchIndex) deleteTermLocked(term string) {
+	if i := sort.SearchStrings(idx.terms, term); i < len(idx.terms) && idx.terms[i] == term {
+		idx.terms = append(idx.terms[:i], idx.terms[i+1:]...)
+	}
+}
+
+// expand lists the indexed terms a query word matches with their weight: the word itself
+// and, for words of minPrefixLen or more, every term it is a prefix of.
+func (idx *searchIndex) expand(word string) map[string]float64 {
+	matches := map[string]float64{}
+	if _, ok := idx.postings[word]; ok {
+		matches[word] = 1
+	}
+	if len([]rune(word)) < minPrefixLen {
+		return matches
+	}
+	for i := sort.SearchStrings(idx.terms, word); i < len(idx.terms) && len(matches) < maxExpansions; i++ {
+		term := idx.terms[i]
+		if !strings.HasPrefix(term, word) {
+			break
+		}
+		if term != word {
+			matches[term] = prefixWeight
+		}
+	}
+	return matches
+}
+
+// searchHit is one ranked result
+type searchHit struct {
+	Book       Book              `json:"book"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"` // field -> snippet with <mark>ed matches, HTML-escaped
+}
+
+// search ranks the books matching any word of the query with BM25.
+// Each query word scores with its best matching term, so a prefix matching many words doesn't pile up.
+func (idx *searchIndex) search(query string) []searchHit {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	n := float64(len(idx.docs))
+	if n == 0 {
+		return nil
+	}
+	avgLen := idx.totalLen / n
+
+	scores := map[BookID]float64{}
+	matched := map[BookID]map[string]bool{} // terms to highlight per book
+	for _, word := range uniqueTerms(tokenize(query)) {
+		best := map[BookID]float64{}
+		for term, weight := range idx.expand(word) {
+			df := float64(len(idx.postings[term]))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for id := range idx.postings[term] {
+				doc := idx.docs[id]
+				tf := doc.terms[term]
+				score := weight * idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*doc.length/avgLen))
+				best[id] = max(best[id], score)
+				if matched[id] == nil {
+					matched[id] = map[string]bool{}
+				}
+				matched[id][term] = true
+			}
+		}
+		for id, score := range best {
+			scores[id] += score
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, score := range scores {
+		doc := idx.docs[id]
+		hits = append(hits, searchHit{
+			Book:       doc.book,
+			Score:      math.Round(score*1000) / 1000,
+			Highlights: highlights(doc.book, matched[id]),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Book.ID.Less(hits[j].Book.ID) // stable order for equal scores
+	})
+	return hits
+}
+
+// highlights builds a snippet for every field of the book that contains a matched term
+func highlights(book Book, terms map[string]bool) map[string]string {
+	out := map[string]string{}
+	for _, field := range searchFields {
+		if snippet, ok := snippet(field.value(book), terms); ok {
+			out[field.name] = snippet
+		}
+	}
+	return out
+}
+
+// snippet escapes text and wraps the matched words in <mark>.
+// Long texts are cut to a window around the first match.
+func snippet(text string, terms map[string]bool) (string, bool) {
+	var marks []token
+	for _, tok := range tokenize(text) {
+		if terms[tok.term] {
+			marks = append(marks, tok)
+		}
+	}
+	if len(marks) == 0 {
+		return "", false
+	}
+
+	start, end := 0, len(text)
+	if end-start > 2*snippetContext+marks[0].end-marks[0].start {
+		start = max(0, marks[0].start-snippetContext)
+		end = min(len(text), marks[0].end+snippetContext)
+		for start > 0 && !isBoundary(text, start-1) { // don't cut a character or word in half
+			start--
+		}
+		for end < len(text) && !isBoundary(text, end) {
+			end++
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range marks {
+		if m.start < pos || m.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:m.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[m.start:m.end]) + "</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// isBoundary reports whether i is a space in text, a good place to cut a snippet
+func isBoundary(text string, i int) bool {
+	return text[i] == ' '
+}
+
+// token is a folded word and where it was found in the original text
+type token struct {
+	term       string
+	start, end int // byte offsets in the original text
+}
+
+// tokenize splits text into words of letters and digits and folds them:
+// lower case and without diacritics, so "Émile" and "emile" are the same word.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{term: fold(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: fold(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// letters that don't decompose into a base letter and a diacritic
+var foldSpecial = strings.NewReplacer("ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "ı", "i")
+
+// fold lower-cases a word and strips its diacritics: decompose (NFD), then drop the combining marks
+func fold(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(word)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return foldSpecial.Replace(b.String())
+}
+
+// uniqueTerms drops repeated query words
+func uniqueTerms(tokens []token) []string {
+	seen := map[string]bool{}
+	var terms []string
+	for _, tok := range tokens {
+		if !seen[tok.term] {
+			seen[tok.term] = true
+			terms = append(terms, tok.term)
+		}
+	}
+	return terms
+}
+
+// searchPage is the JSON envelope returned by GET /books/search
+type searchPage struct {
+	Data []searchHit `json:"data"`
+	Meta pageMeta    `json:"meta"`
+}
+
+// searchBooks handles GET /books/search?q=&limit=&offset=, best matches first
+func (h *bookHandler) searchBooks(c *fiber.Ctx) error {
+	query := strings.TrimSpace(c.Query("q"))
+	if len(tokenize(query)) == 0 {
+		return probInvalidQuery.with("q must contain at least one word")
+	}
+	limit, offset := defaultPageSize, 0
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			return probInvalidQuery.with("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		limit = n
+	}
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return probInvalidQuery.with("offset must be a non-negative number")
+		}
+		offset = n
+	}
+
+	hits := h.search.search(query)
+	total := len(hits)
+	hits = hits[min(offset, total):min(offset+limit, total)]
+	return c.JSON(searchPage{
+		Data: hits,
+		Meta: pageMeta{Total: total, Count: len(hits), Limit: limit, Offset: offset},
+	})
+}
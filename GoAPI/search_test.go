@@ -0,0 +1,118 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// newTestIndex indexes a small catalog where the ranking is easy to reason about
+func newTestIndex() *searchIndex {
+	idx := &searchIndex{docs: map[BookID]*searchDoc{}, postings: map[string]map[BookID]bool{}}
+	idx.load([]Book{
+		{ID: "1", Title: "The Go Programming Language", Author: "Alan Donovan", Tags: []string{"go"}},
+		{ID: "2", Title: "Programming Pearls", Author: "Jon Bentley"},
+		{ID: "3", Title: "Go in Action", Author: "William Kennedy"},
+		{ID: "4", Title: "Les Misérables", Author: "Victor Hugo"},
+		{ID: "5", Title: "Kennedy", Author: "Ted Sorensen"},
+		{ID: "6", Title: "Goroutines", Author: "Rob Pike"},
+	})
+	return idx
+}
+
+// hitIDs lists the IDs of the hits, best first
+func hitIDs(hits []searchHit) []BookID {
+	ids := make([]BookID, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.Book.ID
+	}
+	return ids
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := newTestIndex()
+	tests := []struct {
+		name, query string
+		want        []BookID
+	}{
+		{"title counts more than author", "kennedy", []BookID{"5", "3"}},
+		{"shorter book first", "programming", []BookID{"2", "1"}},
+		{"prefix", "prog", []BookID{"2", "1"}},
+		{"diacritics and case are folded", "MISERABLES", []BookID{"4"}},
+		{"any word matches", "pearls hugo", []BookID{"2", "4"}},
+		{"repeated words count once", "hugo hugo", []BookID{"4"}},
+		{"one letter only matches exactly", "g", nil},
+		{"no match", "haskell", nil},
+	}
+	for _, tt := range tests {
+		if got := hitIDs(idx.search(tt.query)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: search(%q) = %v, want %v", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchScores(t *testing.T) {
+	idx := newTestIndex()
+	if got := hitIDs(idx.search("go kennedy")); len(got) != 4 || got[0] != "3" {
+		t.Errorf("search(go kennedy) = %v, want the book matching both words first", got)
+	}
+	hits := idx.search("kennedy")
+	if len(hits) != 2 || hits[0].Score <= hits[1].Score || hits[1].Score <= 0 {
+		t.Fatalf("scores %+v, want two positive scores, best first", hits)
+	}
+	// a word in every book tells nothing apart: its IDF is close to zero
+	common := &searchIndex{docs: map[BookID]*searchDoc{}, postings: map[string]map[BookID]bool{}}
+	common.load([]Book{{ID: "1", Title: "Go"}, {ID: "2", Title: "Go"}, {ID: "3", Title: "Go Rust"}})
+	if rare, all := common.search("rust")[0].Score, common.search("go")[0].Score; rare <= all {
+		t.Errorf("rare word scored %v, word in every book %v", rare, all)
+	}
+	// all else being equal, the exact word counts more than a word it is a prefix of
+	prefix := &searchIndex{docs: map[BookID]*searchDoc{}, postings: map[string]map[BookID]bool{}}
+	prefix.load([]Book{{ID: "1", Title: "Gopher"}, {ID: "2", Title: "Go"}})
+	if got := hitIDs(prefix.search("go")); !slices.Equal(got, []BookID{"2", "1"}) {
+		t.Errorf("search(go) = %v, want the exact match first", got)
+	}
+}
+
+func TestSearchFollowsChanges(t *testing.T) {
+	idx := newTestIndex()
+	idx.onChange(bookChange{Op: opUpdate, Book: Book{ID: "2", Title: "Haskell Pearls", Author: "Jon Bentley"}})
+	if got := hitIDs(idx.search("programming")); !slices.Equal(got, []BookID{"1"}) {
+		t.Errorf("old title still found: %v", got)
+	}
+	if got := hitIDs(idx.search("haskell")); !slices.Equal(got, []BookID{"2"}) {
+		t.Errorf("new title not found: %v", got)
+	}
+	idx.onChange(bookChange{Op: opDelete, Book: Book{ID: "2"}})
+	if got := idx.search("pearls"); len(got) != 0 {
+		t.Errorf("deleted book found: %v", hitIDs(got))
+	}
+	if slices.Contains(idx.terms, "pearls") {
+		t.Error("term of the deleted book is still listed")
+	}
+	idx.onChange(bookChange{Op: opCreateBatch, Books: []Book{{ID: "7", Title: "Pearls Again"}}})
+	if got := hitIDs(idx.search("pearls")); !slices.Equal(got, []BookID{"7"}) {
+		t.Errorf("batch not indexed: %v", got)
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	idx := &searchIndex{docs: map[BookID]*searchDoc{}, postings: map[string]map[BookID]bool{}}
+	idx.load([]Book{{ID: "1", Title: "<Émile> & Co", Author: "Jean-Jacques Rousseau", Tags: []string{"education"}}})
+	hits := idx.search("emile educ")
+	if len(hits) != 1 {
+		t.Fatalf("got %d hits", len(hits))
+	}
+	want := map[string]string{
+		"title": "&lt;<mark>Émile</mark>&gt; &amp; Co",
+		"tags":  "<mark>education</mark>",
+	}
+	if got := hits[0].Highlights; len(got) != len(want) || got["title"] != want["title"] || got["tags"] != want["tags"] {
+		t.Errorf("highlights %q, want %q", got, want)
+	}
+
+	long := "A very long description that goes on and on before it finally mentions gophers and then keeps going for a while longer"
+	got, ok := snippet(long, map[string]bool{"gophers": true})
+	if !ok || got != "…goes on and on before it finally mentions <mark>gophers</mark> and then keeps going for a while longer" {
+		t.Errorf("snippet %q", got)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrBookNotFound is returned by a BookStore when no book has the requested ID (or ISBN)
+var ErrBookNotFound = errors.New("book not found")
+
+// ErrISBNTaken is returned when a create or update would give two books the same ISBN
+var ErrISBNTaken = errors.New("another book already has this ISBN")
+
+// BookStore is everything the handlers need from the book storage.
+// Implementations must be safe for concurrent use because Fiber serves requests in parallel.
+type BookStore interface {
+	List() []Book
+	Get(id BookID) (Book, error)
+	// GetByISBN finds a book by its normalized ISBN-13
+	GetByISBN(isbn string) (Book, error)
+	Create(book Book) (Book, error)
+	// CreateMany adds all books as one change: either every book is stored or none is
+	CreateMany(books []Book) ([]Book, error)
+	// Update runs fn on a copy of the stored book while holding the store lock,
+	// so read-modify-write cycles can't lose each other's changes.
+	// If fn returns an error the stored book is left untouched.
+	Update(id BookID, fn func(book *Book) error) (Book, error)
+	// Delete removes the book if check (when not nil) accepts it, check runs under the store lock
+	Delete(id BookID, check func(book Book) error) error
+
+	// subscribe hands load the current books and registers observe for every later change,
+	// both under the store lock so a subscriber (e.g. the search index) never misses a change.
+	// Observers only see a change once every hook that could cancel it (the file store's journal)
+	// succeeded and it was applied, so they never hear of a change that isn't kept.
+	subscribe(load func(books []Book), observe changeObserver)
+}
+
+// bookChange describes a single mutation of the store.
+// Book holds the new state for create and update, and the removed book for delete.
+// A batch create carries its books in Books instead, so it is applied (and journaled) as a whole.
+type bookChange struct {
+	Op    string `json:"op"` // "create", "create_batch", "update" or "delete"
+	Book  Book   `json:"book"`
+	Books []Book `json:"books,omitempty"`
+}
+
+const (
+	opCreate      = "create"
+	opCreateBatch = "create_batch"
+	opUpdate      = "update"
+	opDelete      = "delete"
+)
+
+// affected lists the books the change touches
+func (change bookChange) affected() []Book {
+	if change.Op == opCreateBatch {
+		return change.Books
+	}
+	return []Book{change.Book}
+}
+
+// changeHook is called with the store lock held, right before a change is applied.
+// Returning an error cancels the change.
+type changeHook func(change bookChange) error
+
+// changeObserver is called with the store lock held, right after a change was applied.
+// It can't fail: the journal already has the change, so there is nothing left to cancel.
+type changeObserver func(change bookChange)
+
+// memoryBookStore keeps the books in a slice guarded by a RWMutex
+// many readers can hold the lock at once, but writers get it exclusively
+type memoryBookStore struct {
+	mu        sync.RWMutex
+	books     []Book
+	hooks     []changeHook
+	observers []changeObserver
+	ids       idAllocator
+}
+
+func newMemoryBookStore(ids idAllocator) *memoryBookStore {
+	return &memoryBookStore{ids: ids}
+}
+
+func (s *memoryBookStore) List() []Book {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// return a copy so callers can't modify the slice behind our back
+	out := make([]Book, len(s.books))
+	copy(out, s.books)
+	return out
+}
+
+func (s *memoryBookStore) Get(id BookID) (Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if idx := s.indexOf(id); idx >= 0 {
+		return s.books[idx], nil
+	}
+	return Book{}, ErrBookNotFound
+}
+
+func (s *memoryBookStore) GetByISBN(isbn string) (Book, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if idx := s.indexOfISBN(isbn); isbn != "" && idx >= 0 {
+		return s.books[idx], nil
+	}
+	return Book{}, ErrBookNotFound
+}
+
+func (s *memoryBookStore) Create(book Book) (Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isbnTaken(book.ISBN, "") {
+		return Book{}, ErrISBNTaken
+	}
+	book.ID = s.ids.Next() // Assign a fresh ID, never one that was used before
+	book.Version = 1
+	if err := s.commit(bookChange{Op: opCreate, Book: book}); err != nil {
+		return Book{}, err
+	}
+	return book, nil
+}
+
+func (s *memoryBookStore) CreateMany(books []Book) ([]Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	isbns := map[string]bool{} // the batch must not collide with itself either
+	for _, book := range books {
+		if book.ISBN == "" {
+			continue
+		}
+		if isbns[book.ISBN] || s.isbnTaken(book.ISBN, "") {
+			return nil, fmt.Errorf("%w: %s", ErrISBNTaken, book.ISBN)
+		}
+		isbns[book.ISBN] = true
+	}
+
+	created := make([]Book, len(books))
+	for i, book := range books {
+		book.ID = s.ids.Next()
+		book.Version = 1
+		created[i] = book
+	}
+	if err := s.commit(bookChange{Op: opCreateBatch, Books: created}); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *memoryBookStore) Update(id BookID, fn func(book *Book) error) (Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := s.indexOf(id)
+	if idx < 0 {
+		return Book{}, ErrBookNotFound
+	}
+
+	updated := s.books[idx] // work on a copy, only store it if fn succeeds
+	if err := fn(&updated); err != nil {
+		return Book{}, err
+	}
+	updated.ID = id // the ID can never be changed by an update
+	updated.Version = s.books[idx].Version + 1
+	if s.isbnTaken(updated.ISBN, id) {
+		return Book{}, ErrISBNTaken
+	}
+	if err := s.commit(bookChange{Op: opUpdate, Book: updated}); err != nil {
+		return Book{}, err
+	}
+	return updated, nil
+}
+
+func (s *memoryBookStore) Delete(id BookID, check func(book Book) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := s.indexOf(id)
+	if idx < 0 {
+		return ErrBookNotFound
+	}
+	if check != nil {
+		if err := check(s.books[idx]); err != nil {
+			return err
+		}
+	}
+	return s.commit(bookChange{Op: opDelete, Book: s.books[idx]})
+}
+
+// addHook registers a hook that sees every change before it is applied
+func (s *memoryBookStore) addHook(hook changeHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+func (s *memoryBookStore) subscribe(load func(books []Book), observe changeObserver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	load(append([]Book(nil), s.books...))
+	s.observers = append(s.observers, observe)
+}
+
+// commit runs the hooks, applies the change and then tells the observers.
+// Callers must hold s.mu for writing.
+func (s *memoryBookStore) commit(change bookChange) error {
+	for _, hook := range s.hooks {
+		if err := hook(change); err != nil {
+			return err
+		}
+	}
+	s.apply(change)
+	for _, observe := range s.observers {
+		observe(change)
+	}
+	return nil
+}
+
+// apply changes the slice without running any hooks, used directly when replaying a journal.
+// Callers must hold s.mu for writing.
+func (s *memoryBookStore) apply(change bookChange) {
+	if change.Op == opCreateBatch {
+		for _, book := range change.Books {
+			s.apply(bookChange{Op: opCreate, Book: book})
+		}
+		return
+	}
+
+	idx := s.indexOf(change.Book.ID)
+	switch change.Op {
+	case opCreate:
+		if idx >= 0 {
+			s.books[idx] = change.Book
+			return
+		}
+		s.books = append(s.books, change.Book)
+	case opUpdate:
+		if idx >= 0 {
+			s.books[idx] = change.Book
+		}
+	case opDelete:
+		if idx >= 0 {
+			// Remove the book from the slice by appending the parts before and after it
+			s.books = append(s.books[:idx], s.books[idx+1:]...)
+		}
+	}
+}
+
+// indexOf returns the slice position of the book with the given ID or -1.
+// Callers must hold s.mu.
+func (s *memoryBookStore) indexOf(id BookID) int {
+	for idx, book := range s.books {
+		if book.ID == id {
+			return idx
+		}
+	}
+	return -1
+}
+
+// indexOfISBN returns the slice position of the book with the given ISBN or -1.
+// Callers must hold s.mu.
+func (s *memoryBookStore) indexOfISBN(isbn string) int {
+	for idx, book := range s.books {
+		if book.ISBN == isbn {
+			return idx
+		}
+	}
+	return -1
+}
+
+// isbnTaken reports whether a book other than except already has the ISBN, books without one never clash.
+// Callers must hold s.mu.
+func (s *memoryBookStore) isbnTaken(isbn string, except BookID) bool {
+	if isbn == "" {
+		return false
+	}
+	idx := s.indexOfISBN(isbn)
+	return idx >= 0 && s.books[idx].ID != except
+}
+
+// sampleBooks are the books a brand new store starts with, they get their IDs from the store
+func sampleBooks() []Book {
+	return []Book{
+		{
+			Title:     "The Go Programming Language",
+			Author:    "Alan Donovan",
+			ISBN:      "9780134190440",
+			Year:      2015,
+			Publisher: "Addison-Wesley",
+			Language:  "en",
+			Pages:     380,
+			Tags:      []string{"go", "programming"},
+		},
+		{
+			Title:     "Learning Go",
+			Author:    "Jon Bodner",
+			ISBN:      "9781492077213",
+			Year:      2021,
+			Publisher: "O'Reilly",
+			Language:  "en",
+			Pages:     375,
+			Tags:      []string{"go", "programming"},
+		},
+		{
+			Title:     "Go in Action",
+			Author:    "William Kennedy",
+			ISBN:      "9781617291784",
+			Year:      2015,
+			Publisher: "Manning",
+			Language:  "en",
+			Pages:     264,
+			Tags:      []string{"go", "programming"},
+		},
+	}
+}
+
+// seedBooks adds the sample books to an empty store
+func seedBooks(store BookStore) error {
+	for _, book := range sampleBooks() {
+		if _, err := store.Create(book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openBookStore picks the storage backend from the BOOK_STORE setting.
+//   - "memory": books live in memory only and are reseeded on every start
+//   - "file": books are journaled to BOOK_DATA_DIR and survive restarts,
+//     with a compacted snapshot every BOOK_SNAPSHOT_EVERY changes
+func openBookStore(cfg *appConfig, ids idAllocator) (BookStore, error) {
+	switch backend := cfg.Get("BOOK_STORE"); backend {
+	case "memory":
+		store := newMemoryBookStore(ids)
+		return store, seedBooks(store)
+	case "file":
+		store, err := openFileBookStore(cfg.Get("BOOK_DATA_DIR"), cfg.Int("BOOK_SNAPSHOT_EVERY"), ids)
+		if err != nil {
+			return nil, err
+		}
+		if store.fresh {
+			// only seed the very first time, after that the journal is the source of truth
+			if err := seedBooks(store); err != nil {
+				return nil, err
+			}
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown BOOK_STORE %q (want \"memory\" or \"file\")", backend)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *memoryBookStore {
+	t.Helper()
+	ids, err := newIDAllocator("sequential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryBookStore(ids)
+	if err := seedBooks(store); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// TestMemoryBookStoreConcurrent runs writers and readers at once; run it with -race.
+// Every create must get its own ID and no read-modify-write of Update may be lost.
+func TestMemoryBookStoreConcurrent(t *testing.T) {
+	store := newTestStore(t)
+	const workers = 8
+	const perWorker = 50
+
+	var wg sync.WaitGroup
+	ids := make(chan BookID, workers*perWorker)
+	for w := range workers {
+		wg.Add(3)
+		go func() { // creates
+			defer wg.Done()
+			for i := range perWorker {
+				book, err := store.Create(Book{Title: fmt.Sprintf("Book %d-%d", w, i), Author: "Gopher"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- book.ID
+			}
+		}()
+		go func() { // everybody counts the pages of the same book up by one
+			defer wg.Done()
+			for range perWorker {
+				_, err := store.Update("1", func(book *Book) error {
+					book.Pages++
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() { // and reads while the others write
+			defer wg.Done()
+			for range perWorker {
+				for _, book := range store.List() {
+					if _, err := store.Get(book.ID); err != nil && !errors.Is(err, ErrBookNotFound) {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[BookID]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("ID %s was given to two books", id)
+		}
+		seen[id] = true
+	}
+	if got, want := len(store.List()), len(sampleBooks())+workers*perWorker; got != want {
+		t.Errorf("%d books stored, want %d", got, want)
+	}
+	book, err := store.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sampleBooks()[0].Pages + workers*perWorker; book.Pages != want || book.Version != 1+workers*perWorker {
+		t.Errorf("pages %d at version %d, want %d at version %d", book.Pages, book.Version, want, 1+workers*perWorker)
+	}
+}
+
+func TestMemoryBookStoreErrors(t *testing.T) {
+	store := newTestStore(t)
+	sample := sampleBooks()[0]
+
+	if _, err := store.Create(Book{Title: "Copy", Author: "Gopher", ISBN: sample.ISBN}); !errors.Is(err, ErrISBNTaken) {
+		t.Errorf("create with a taken ISBN: %v", err)
+	}
+	if _, err := store.CreateMany([]Book{{Title: "A", ISBN: "9781491941195"}, {Title: "B", ISBN: "9781491941195"}}); !errors.Is(err, ErrISBNTaken) {
+		t.Errorf("batch with the same ISBN twice: %v", err)
+	}
+	if _, err := store.Get("999"); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("get unknown ID: %v", err)
+	}
+
+	// a failing update leaves the book untouched
+	refused := errors.New("refused")
+	_, err := store.Update("1", func(book *Book) error {
+		book.Title = "Changed"
+		return refused
+	})
+	if !errors.Is(err, refused) {
+		t.Errorf("update: %v, want the error of fn", err)
+	}
+	if book, _ := store.Get("1"); book.Title != sample.Title || book.Version != 1 {
+		t.Errorf("book changed by a failed update: %+v", book)
+	}
+
+	if err := store.Delete("1", func(Book) error { return refused }); !errors.Is(err, refused) {
+		t.Errorf("delete: %v, want the error of check", err)
+	}
+	if err := store.Delete("1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("1", nil); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("second delete: %v", err)
+	}
+	// IDs are never handed out again
+	if book, _ := store.Create(Book{Title: "New", Author: "Gopher"}); book.ID == "1" {
+		t.Error("the ID of a deleted book was reused")
+	}
+}
+
+// TestMemoryBookStoreCommitOrder checks that a hook that fails, like a journal that can't be written,
+// cancels the change before any observer hears of it
+func TestMemoryBookStoreCommitOrder(t *testing.T) {
+	store := newTestStore(t)
+	var observed []string
+	store.subscribe(func([]Book) {}, func(change bookChange) { observed = append(observed, change.Op) })
+
+	journalFull := errors.New("no space left on device")
+	store.addHook(func(change bookChange) error {
+		if change.Book.Title == "Doomed" {
+			return journalFull
+		}
+		return nil
+	})
+
+	if _, err := store.Create(Book{Title: "Doomed", Author: "Gopher"}); !errors.Is(err, journalFull) {
+		t.Fatalf("create = %v, want the error of the hook", err)
+	}
+	if len(observed) != 0 || len(store.List()) != len(sampleBooks()) {
+		t.Fatalf("cancelled change was observed (%v) or stored (%d books)", observed, len(store.List()))
+	}
+	if _, err := store.Create(Book{Title: "Kept", Author: "Gopher"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(observed) != 1 || observed[0] != opCreate {
+		t.Errorf("observed %v, want the one create that was kept", observed)
+	}
+}
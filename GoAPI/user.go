@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/loginlimit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// userHandler groups login, registration and the admin-only user management routes
+type userHandler struct {
+	users     UserStore
+	passwords *passwordHasher
+	tokens    *tokenService
+	limiter   *loginlimit.Limiter // login attempts per client IP and per account
+}
+
+func newUserHandler(users UserStore, passwords *passwordHasher, tokens *tokenService, limiter *loginlimit.Limiter) *userHandler {
+	return &userHandler{users: users, passwords: passwords, tokens: tokens, limiter: limiter}
+}
+
+// login checks the credentials and starts a new session with an access and a refresh token
+func (h *userHandler) login(c *fiber.Ctx) error {
+	creds := new(credentials)
+	if err := c.BodyParser(creds); err != nil {
+		return probMalformedRequest.wrap(err)
+	}
+
+	// throttle before checking the password, so a refused attempt doesn't even cost a bcrypt hash
+	if d := h.limiter.Allow(c.IP(), creds.Username); !d.Allowed {
+		return tooManyAttempts(c, d)
+	}
+
+	// both the username AND the password must match, and the answer never says which one didn't
+	user, err := h.passwords.authenticate(h.users, creds.Username, creds.Password)
+	if err != nil {
+		h.limiter.Failure(creds.Username) // enough failures in a row lock the account
+		return errBadCredentials
+	}
+	h.limiter.Success(creds.Username)
+
+	// Generate the short-lived JWT and a refresh token to get the next one
+	pair, err := h.tokens.issue(user)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(loginResponse{
+		Message:   "Login successful",
+		Username:  user.Username,
+		tokenPair: pair,
+	})
+}
+
+// loginResponse is the body of a successful login: the token pair plus who logged in
+type loginResponse struct {
+	Message  string `json:"message"`
+	Username string `json:"username"`
+	tokenPair
+}
+
+// register lets anyone create a reader account
+func (h *userHandler) register(c *fiber.Ctx) error {
+	creds := new(credentials)
+	if err := c.BodyParser(creds); err != nil {
+		return probMalformedRequest.wrap(err)
+	}
+
+	created, err := h.createAccount(creds.Username, creds.Password, roleReader)
+	if err != nil {
+		return userError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+func (h *userHandler) listUsers(c *fiber.Ctx) error {
+	return c.JSON(h.users.List())
+}
+
+func (h *userHandler) getUser(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return probInvalidID.with("user id must be a number")
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		return userError(err)
+	}
+	return c.JSON(user)
+}
+
+// userInput is the body of the admin create and update routes
+type userInput struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// createUser lets an admin create an account with any role
+func (h *userHandler) createUser(c *fiber.Ctx) error {
+	input := new(userInput)
+	if err := c.BodyParser(input); err != nil {
+		return probMalformedRequest.wrap(err)
+	}
+	if input.Role == "" {
+		input.Role = roleReader
+	}
+
+	created, err := h.createAccount(input.Username, input.Password, input.Role)
+	if err != nil {
+		return userError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+// updateUser changes the username, password and/or role, empty fields are left as they are
+func (h *userHandler) updateUser(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return probInvalidID.with("user id must be a number")
+	}
+	input := new(userInput)
+	if err := c.BodyParser(input); err != nil {
+		return probMalformedRequest.wrap(err)
+	}
+	if input.Role != "" && !validRole(input.Role) {
+		return probInvalidUser.with("unknown role " + strconv.Quote(input.Role))
+	}
+
+	// hash outside of the store lock, bcrypt is slow on purpose
+	var hash string
+	if input.Password != "" {
+		if err := validatePassword(input.Password); err != nil {
+			return probInvalidUser.wrap(err)
+		}
+		if hash, err = h.passwords.hash(input.Password); err != nil {
+			return err
+		}
+	}
+
+	updated, err := h.users.Update(userID, func(user *User) error {
+		if input.Username != "" {
+			if err := validateUsername(input.Username); err != nil {
+				return err
+			}
+			user.Username = input.Username
+		}
+		if hash != "" {
+			user.PasswordHash = hash
+		}
+		if input.Role != "" {
+			user.Role = input.Role
+		}
+		return nil
+	})
+	if err != nil {
+		return userError(err)
+	}
+	return c.JSON(updated)
+}
+
+func (h *userHandler) deleteUser(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return probInvalidID.with("user id must be a number")
+	}
+	if sub, _ := tokenClaims(c)["uid"].(float64); int(sub) == userID {
+		return probInvalidUser.with("you can't delete your own account")
+	}
+
+	if err := h.users.Delete(userID); err != nil {
+		return userError(err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// createAccount validates and hashes before storing a new user
+func (h *userHandler) createAccount(username, password, role string) (User, error) {
+	if err := validateUsername(username); err != nil {
+		return User{}, err
+	}
+	if err := validatePassword(password); err != nil {
+		return User{}, err
+	}
+	if !validRole(role) {
+		return User{}, errors.New("unknown role " + strconv.Quote(role))
+	}
+	hash, err := h.passwords.hash(password)
+	if err != nil {
+		return User{}, err
+	}
+	return h.users.Create(User{Username: username, PasswordHash: hash, Role: role})
+}
+
+// userError keeps the user store errors the error handler knows (404, 409),
+// anything else is a validation problem
+func userError(err error) error {
+	if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrUsernameTaken) {
+		return err
+	}
+	return probInvalidUser.wrap(err)
+}
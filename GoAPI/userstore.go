@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// user roles, carried in the JWT so the middleware doesn't need to look the user up
+const (
+	roleReader = "reader" // can read books
+	roleEditor = "editor" // can also create, change and delete books
+	roleAdmin  = "admin"  // can also manage users
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrUsernameTaken  = errors.New("username is already taken")
+	errBadCredentials = errors.New("invalid username or password")
+)
+
+// User is an account that can log in. The password is only ever stored as a bcrypt hash.
+type User struct {
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"` // never sent to clients
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// credentials is the body of login and registration requests
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserStore keeps the accounts. Like BookStore it must be safe for concurrent use.
+type UserStore interface {
+	List() []User
+	Get(id int) (User, error)
+	GetByUsername(username string) (User, error)
+	Create(user User) (User, error)
+	Update(id int, fn func(user *User) error) (User, error)
+	Delete(id int) error
+}
+
+// memoryUserStore keeps the users in a map guarded by a RWMutex
+type memoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]User
+	lastID int // only goes up, like the sequential book IDs
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{users: map[int]User{}}
+}
+
+func (s *memoryUserStore) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		out = append(out, u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *memoryUserStore) Get(id int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *memoryUserStore) GetByUsername(username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if strings.EqualFold(u.Username, username) {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *memoryUserStore) Create(user User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.usernameTaken(user.Username, 0) {
+		return User{}, ErrUsernameTaken
+	}
+	s.lastID++
+	user.ID = s.lastID
+	user.CreatedAt = time.Now().UTC()
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *memoryUserStore) Update(id int, fn func(user *User) error) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, ok := s.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	if err := fn(&updated); err != nil {
+		return User{}, err
+	}
+	if s.usernameTaken(updated.Username, id) {
+		return User{}, ErrUsernameTaken
+	}
+	updated.ID = id
+	s.users[id] = updated
+	return updated, nil
+}
+
+func (s *memoryUserStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// usernameTaken reports whether another user (not exceptID) has the username. Callers must hold s.mu.
+func (s *memoryUserStore) usernameTaken(username string, exceptID int) bool {
+	for _, u := range s.users {
+		if u.ID != exceptID && strings.EqualFold(u.Username, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordHasher hashes and checks passwords with bcrypt at the cost set by BCRYPT_COST
+type passwordHasher struct {
+	cost int
+	// dummyHash is compared against when the username does not exist,
+	// so a login for an unknown user takes as long as one with a wrong password
+	dummyHash []byte
+}
+
+func newPasswordHasher(cost int) (*passwordHasher, error) {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), cost)
+	if err != nil {
+		return nil, err
+	}
+	return &passwordHasher{cost: cost, dummyHash: dummy}, nil
+}
+
+// hash returns the bcrypt hash of a password
+func (p *passwordHasher) hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.cost)
+	return string(hash), err
+}
+
+// authenticate checks BOTH the username and the password.
+// Every failure returns the same error so callers can't tell which one was wrong.
+// Hashes made at another cost still verify, the cost is part of the hash.
+func (p *passwordHasher) authenticate(users UserStore, username, password string) (User, error) {
+	user, err := users.GetByUsername(username)
+	if err != nil {
+		_ = bcrypt.CompareHashAndPassword(p.dummyHash, []byte(password))
+		return User{}, errBadCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return User{}, errBadCredentials
+	}
+	return user, nil
+}
+
+// validateUsername and validatePassword are the rules used by registration and user management
+func validateUsername(username string) error {
+	if n := len(username); n < 3 || n > 32 {
+		return errors.New("username must be between 3 and 32 characters")
+	}
+	if strings.ContainsAny(username, " \t\n") {
+		return errors.New("username must not contain whitespace")
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < 8 || len(password) > 72 { // bcrypt ignores everything after 72 bytes
+		return errors.New("password must be between 8 and 72 characters")
+	}
+	return nil
+}
+
+func validRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// seedAdmin creates the first admin account from ADMIN_USERNAME / ADMIN_PASSWORD
+// so there is someone who can manage the other users
+func seedAdmin(users UserStore, passwords *passwordHasher, username, password string) error {
+	hash, err := passwords.hash(password)
+	if err != nil {
+		return err
+	}
+	_, err = users.Create(User{Username: username, PasswordHash: hash, Role: roleAdmin})
+	return err
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// fieldError is one failing field of a 422 response
+type fieldError struct {
+	Field   string `json:"field,omitempty"` // empty when the whole row is broken
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// validationErrors lists every failing field, not just the first one
+type validationErrors []fieldError
+
+func (errs validationErrors) Error() string {
+	parts := make([]string, len(errs))
+	for i, e := range errs {
+		parts[i] = e.Field + " " + e.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// rule checks one field against the parameter written in the tag (e.g. 200 in max=200).
+// It returns a machine-readable code and a message, or ok.
+// A rule may also normalize the value it accepts, e.g. isbn turns an ISBN-10 into an ISBN-13.
+type rule func(field reflect.Value, param string) (code, message string, ok bool)
+
+// rules are used by name in `validate` struct tags, e.g. `validate:"required,max=200"`
+var rules = map[string]rule{
+	"required": func(field reflect.Value, _ string) (string, string, bool) {
+		return "required", "is required", !field.IsZero()
+	},
+	"min": func(field reflect.Value, param string) (string, string, bool) {
+		n, _ := strconv.Atoi(param)
+		if field.Kind() == reflect.Int {
+			return "too_small", "must be at least " + param, field.Int() >= int64(n)
+		}
+		return "too_short", "must have at least " + param + " " + unit(field), size(field) >= n
+	},
+	"max": func(field reflect.Value, param string) (string, string, bool) {
+		n, _ := strconv.Atoi(param)
+		if field.Kind() == reflect.Int {
+			return "too_large", "must be at most " + param, field.Int() <= int64(n)
+		}
+		return "too_long", "must have at most " + param + " " + unit(field), size(field) <= n
+	},
+	"isbn": func(field reflect.Value, _ string) (string, string, bool) {
+		isbn, err := normalizeISBN(field.String())
+		if err != nil {
+			return "invalid_isbn", "must be an ISBN-10 or ISBN-13 with a valid check digit", false
+		}
+		field.SetString(isbn)
+		return "", "", true
+	},
+	"year": func(field reflect.Value, _ string) (string, string, bool) {
+		next := time.Now().Year() + 1 // books are announced before they come out
+		return "invalid_year", "must be a year between 1 and " + strconv.Itoa(next), field.Int() >= 1 && field.Int() <= int64(next)
+	},
+	"language": func(field reflect.Value, _ string) (string, string, bool) {
+		code := strings.ToLower(field.String())
+		field.SetString(code)
+		ok := len(code) == 2 || len(code) == 3
+		for _, r := range code {
+			ok = ok && r >= 'a' && r <= 'z'
+		}
+		return "invalid_language", "must be an ISO 639 language code such as en", ok
+	},
+	"tags": func(field reflect.Value, _ string) (string, string, bool) {
+		// tags are compared lower-case, blanks and duplicates are dropped
+		seen := map[string]bool{}
+		tags := make([]string, 0, field.Len())
+		for i := 0; i < field.Len(); i++ {
+			tag := strings.ToLower(strings.TrimSpace(field.Index(i).String()))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if utf8.RuneCountInString(tag) > maxTagLength {
+				return "invalid_tag", "must only contain tags of at most " + strconv.Itoa(maxTagLength) + " characters", false
+			}
+			if strings.Contains(tag, csvListSeparator) { // it separates the tags in a CSV cell
+				return "invalid_tag", "must not contain " + csvListSeparator + " in a tag", false
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+		field.Set(reflect.ValueOf(tags))
+		return "", "", true
+	},
+}
+
+// maxTagLength is the longest tag a book can have
+const maxTagLength = 30
+
+// unit names what min and max count
+func unit(field reflect.Value) string {
+	if field.Kind() == reflect.Slice {
+		return "items"
+	}
+	return "characters"
+}
+
+// size is the length of a string in characters (not bytes) or the length of a slice
+func size(field reflect.Value) int {
+	if field.Kind() == reflect.String {
+		return utf8.RuneCountInString(field.String())
+	}
+	return field.Len()
+}
+
+// validate trims the whitespace around every string field of the struct ptr points to,
+// then checks the `validate` tags. Fields that are empty and not required skip their other rules.
+func validate(ptr any) error {
+	v := reflect.ValueOf(ptr).Elem()
+	t := v.Type()
+
+	var errs validationErrors
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.String {
+			field.SetString(strings.TrimSpace(field.String()))
+		}
+
+		tag := t.Field(i).Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		for _, spec := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(spec, "=")
+			check, ok := rules[name]
+			if !ok {
+				panic("validate: unknown rule " + name + " on " + t.Name() + "." + t.Field(i).Name) // a typo in a tag should fail loudly
+			}
+			if name != "required" && field.IsZero() {
+				continue // optional and not given
+			}
+			if code, message, ok := check(field, param); !ok {
+				errs = append(errs, fieldError{Field: jsonName(t.Field(i)), Code: code, Message: message})
+				break // one error per field is enough
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeStrict parses a JSON object into the struct ptr points to.
+// Unlike json.Unmarshal it rejects members the struct doesn't have and matches names exactly,
+// and it reports every unknown or mistyped member instead of stopping at the first.
+// A body that isn't a JSON object at all is returned as a plain error.
+func decodeStrict(data []byte, ptr any) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		if syntax := new(json.SyntaxError); errors.As(err, &syntax) {
+			return err
+		}
+		return errors.New("the body must be a JSON object")
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names) // stable order in the report
+
+	v := reflect.ValueOf(ptr).Elem()
+	fields := jsonFields(v.Type())
+	var errs validationErrors
+	for _, name := range names {
+		idx, ok := fields[name]
+		if !ok {
+			errs = append(errs, fieldError{Field: name, Code: "unknown_field", Message: "is not a known field"})
+			continue
+		}
+		field := v.Field(idx)
+		if err := json.Unmarshal(members[name], field.Addr().Interface()); err != nil {
+			errs = append(errs, fieldError{Field: name, Code: "invalid_type", Message: "must be " + typeName(field.Type())})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeAndValidate runs decodeStrict and validate and reports the failures of both together.
+// A field that couldn't be decoded is not checked again by its rules.
+func decodeAndValidate(data []byte, ptr any) error {
+	var errs validationErrors
+	if err := decodeStrict(data, ptr); err != nil && !errors.As(err, &errs) {
+		return err
+	}
+	var ruleErrs validationErrors
+	errors.As(validate(ptr), &ruleErrs)
+	for _, e := range ruleErrs {
+		if !errs.has(e.Field) {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// has reports whether field already failed
+func (errs validationErrors) has(field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonFields maps the JSON names of a struct's exported fields to their index
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() && f.Tag.Get("json") != "-" {
+			fields[jsonName(f)] = i
+		}
+	}
+	return fields
+}
+
+// jsonName is the name a field has in JSON documents
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// typeName describes a Go type in JSON terms for error messages
+func typeName(t reflect.Type) string {
+	if t == reflect.TypeOf(BookID("")) {
+		return "a number or a string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Slice:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(typeName(t.Elem()), "a "), "an ") + "s"
+	}
+	return fmt.Sprintf("a valid %s", t.Name())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fieldCodes lists the failing fields of err as field=code
+func fieldCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs validationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("not a validation error: %v", err)
+	}
+	codes := make([]string, len(errs))
+	for i, e := range errs {
+		codes[i] = e.Field + "=" + e.Code
+	}
+	return codes
+}
+
+func TestValidateRules(t *testing.T) {
+	valid := func() Book { return Book{Title: "Go", Author: "Alan"} }
+	tests := []struct {
+		name string
+		edit func(*Book)
+		want []string // field=code, in field order
+	}{
+		{"valid", func(*Book) {}, nil},
+		{"required", func(b *Book) { b.Title, b.Author = "", "  " }, []string{"title=required", "author=required"}},
+		{"max length counts characters", func(b *Book) { b.Title = strings.Repeat("é", 200) }, nil},
+		{"too long", func(b *Book) { b.Title = strings.Repeat("a", 201) }, []string{"title=too_long"}},
+		{"isbn checksum", func(b *Book) { b.ISBN = "978-0134190441" }, []string{"isbn=invalid_isbn"}},
+		{"year zero is not given", func(b *Book) { b.Year = 0 }, nil},
+		{"negative year", func(b *Book) { b.Year = -5 }, []string{"year=invalid_year"}},
+		{"year far ahead", func(b *Book) { b.Year = 3000 }, []string{"year=invalid_year"}},
+		{"language", func(b *Book) { b.Language = "english" }, []string{"language=invalid_language"}},
+		{"language letters", func(b *Book) { b.Language = "e1" }, []string{"language=invalid_language"}},
+		{"pages too small", func(b *Book) { b.Pages = -1 }, []string{"pages=too_small"}},
+		{"pages too large", func(b *Book) { b.Pages = 100001 }, []string{"pages=too_large"}},
+		{"too many tags", func(b *Book) { b.Tags = make([]string, 21) }, []string{"tags=too_long"}},
+		{"tag with the CSV separator", func(b *Book) { b.Tags = []string{"sci|fi"} }, []string{"tags=invalid_tag"}},
+		{"tag too long", func(b *Book) { b.Tags = []string{strings.Repeat("t", maxTagLength+1)} }, []string{"tags=invalid_tag"}},
+		{"one error per field, every field", func(b *Book) {
+			b.Title, b.Pages, b.Language = "", 0, "x"
+		}, []string{"title=required", "language=invalid_language"}},
+	}
+	for _, tt := range tests {
+		book := valid()
+		tt.edit(&book)
+		if got := fieldCodes(t, validate(&book)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateNormalizes(t *testing.T) {
+	book := Book{
+		Title:    "  Go  ",
+		Author:   "Alan",
+		ISBN:     "0-8044-2957-X",
+		Language: "EN",
+		Tags:     []string{" Go ", "go", "", "Programming"},
+	}
+	if err := validate(&book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "Go" {
+		t.Errorf("title %q, want it trimmed", book.Title)
+	}
+	if book.ISBN != "9780804429573" {
+		t.Errorf("isbn %q, want the ISBN-13", book.ISBN)
+	}
+	if book.Language != "en" {
+		t.Errorf("language %q, want it lower-case", book.Language)
+	}
+	if want := []string{"go", "programming"}; !slices.Equal(book.Tags, want) {
+		t.Errorf("tags %q, want %q", book.Tags, want)
+	}
+}
+
+func TestDecodeAndValidate(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       []string
+		plain      bool // not a validation error at all
+	}{
+		{name: "valid", body: `{"title":"Go","author":"Alan","year":2015}`},
+		{name: "unknown field", body: `{"title":"Go","author":"Alan","rating":5}`, want: []string{"rating=unknown_field"}},
+		{name: "names match exactly", body: `{"Title":"Go","author":"Alan"}`, want: []string{"Title=unknown_field", "title=required"}},
+		{name: "wrong type is not checked again", body: `{"title":42,"author":"Alan"}`, want: []string{"title=invalid_type"}},
+		{name: "decode and rule errors together", body: `{"author":"Alan","pages":"many","year":-1}`,
+			want: []string{"pages=invalid_type", "title=required", "year=invalid_year"}},
+		{name: "not an object", body: `["Go"]`, plain: true},
+		{name: "syntax error", body: `{"title":`, plain: true},
+	}
+	for _, tt := range tests {
+		var book Book
+		err := decodeAndValidate([]byte(tt.body), &book)
+		if tt.plain {
+			var errs validationErrors
+			if err == nil || errors.As(err, &errs) {
+				t.Errorf("%s: got %v, want a plain error", tt.name, err)
+			}
+			continue
+		}
+		if got := fieldCodes(t, err); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
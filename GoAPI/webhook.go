@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// webhookInput is the body of POST /webhooks
+type webhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"` // book.created, book.updated and/or book.deleted
+}
+
+// createdWebhook is the answer to POST /webhooks, the only one that carries the secret
+type createdWebhook struct {
+	Webhook
+	Secret string `json:"secret"` // signs every delivery, see signWebhook
+}
+
+// deliveryLog is the body of GET /webhooks/deliveries
+type deliveryLog struct {
+	Data []Delivery `json:"data"`
+}
+
+// listWebhooks handles GET /webhooks
+func (d *webhookDispatcher) listWebhooks(c *fiber.Ctx) error {
+	return c.JSON(d.Webhooks())
+}
+
+// createWebhook handles POST /webhooks: the URL and the events it wants
+func (d *webhookDispatcher) createWebhook(c *fiber.Ctx) error {
+	input := new(webhookInput)
+	if err := c.BodyParser(input); err != nil {
+		return probMalformedRequest.wrap(err)
+	}
+	events, err := validateWebhook(input, d.cfg.AllowPrivate)
+	if err != nil {
+		return probInvalidWebhook.wrap(err)
+	}
+
+	hook, err := d.Subscribe(input.URL, events)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(createdWebhook{Webhook: hook.Webhook, Secret: hook.Secret})
+}
+
+func (d *webhookDispatcher) getWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return probInvalidID.with("webhook id must be a number")
+	}
+	hook, err := d.Webhook(id)
+	if err != nil {
+		return err //404
+	}
+	return c.JSON(hook)
+}
+
+// deleteWebhook handles DELETE /webhooks/:id, its pending deliveries are dropped
+func (d *webhookDispatcher) deleteWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return probInvalidID.with("webhook id must be a number")
+	}
+	if err := d.Unsubscribe(id); err != nil {
+		return err //404
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// listDeliveries handles GET /webhooks/deliveries, newest first,
+// filtered by ?webhook= ?event= ?status= and cut at ?limit=
+func (d *webhookDispatcher) listDeliveries(c *fiber.Ctx) error {
+	filter := deliveryFilter{event: c.Query("event"), status: c.Query("status"), limit: defaultPageSize}
+	if raw := c.Query("webhook"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id < 1 {
+			return probInvalidQuery.with("webhook must be a webhook id")
+		}
+		filter.webhookID = id
+	}
+	if filter.event != "" && !slices.Contains(webhookEvents, filter.event) {
+		return probInvalidQuery.with("event must be one of " + strings.Join(webhookEvents, ", "))
+	}
+	if filter.status != "" && filter.status != deliveryPending && filter.status != deliveryDelivered && filter.status != deliveryDead {
+		return probInvalidQuery.with("status must be " + deliveryPending + ", " + deliveryDelivered + " or " + deliveryDead)
+	}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			return probInvalidQuery.with("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		filter.limit = n
+	}
+	return c.JSON(deliveryLog{Data: d.Deliveries(filter)})
+}
+
+// getDelivery handles GET /webhooks/deliveries/:id: the payload and every attempt
+func (d *webhookDispatcher) getDelivery(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return probInvalidID.with("delivery id must be a number")
+	}
+	delivery, err := d.Delivery(id)
+	if err != nil {
+		return err //404
+	}
+	return c.JSON(delivery)
+}
+
+// retryDelivery handles POST /webhooks/deliveries/:id/retry, it queues a dead delivery again
+func (d *webhookDispatcher) retryDelivery(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return probInvalidID.with("delivery id must be a number")
+	}
+	delivery, err := d.Retry(id)
+	if err != nil {
+		return err //404 for an unknown delivery or a deleted webhook, 409 unless it is dead
+	}
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
+
+// validateWebhook checks the URL and returns the events without duplicates.
+// Internal hosts are refused right away when they are obvious from the URL,
+// names resolving to them only fail when a delivery connects, see refusePrivateAddress.
+func validateWebhook(input *webhookInput, allowPrivate bool) ([]string, error) {
+	u, err := url.Parse(input.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("url must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return nil, errors.New("url must not contain credentials, deliveries are signed instead")
+	}
+	if !allowPrivate {
+		host := strings.ToLower(u.Hostname())
+		ip, err := netip.ParseAddr(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || (err == nil && isPrivateAddress(ip)) {
+			return nil, errPrivateAddress
+		}
+	}
+	if len(input.Events) == 0 {
+		return nil, errors.New("events must name at least one of " + strings.Join(webhookEvents, ", "))
+	}
+	var events []string
+	for _, event := range input.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return nil, errors.New("unknown event " + strconv.Quote(event) + ", use " + strings.Join(webhookEvents, ", "))
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
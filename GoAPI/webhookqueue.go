@@ -0,0 +1,811 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// book events a webhook can subscribe to
+const (
+	eventBookCreated = "book.created"
+	eventBookUpdated = "book.updated"
+	eventBookDeleted = "book.deleted"
+)
+
+var webhookEvents = []string{eventBookCreated, eventBookUpdated, eventBookDeleted}
+
+// states of a delivery
+const (
+	deliveryPending   = "pending"   // waiting for its next attempt
+	deliveryDelivered = "delivered" // the subscriber answered 2xx
+	deliveryDead      = "dead"      // every attempt failed, only a manual retry sends it again
+)
+
+// headers of every delivery, see signWebhook
+const (
+	headerWebhookID        = "X-Webhook-ID" // the event ID, the same on every attempt so receivers can drop duplicates
+	headerWebhookEvent     = "X-Webhook-Event"
+	headerWebhookTimestamp = "X-Webhook-Timestamp"
+	headerWebhookSignature = "X-Webhook-Signature"
+)
+
+const (
+	webhooksFile        = "webhooks.json"          // snapshot of the state
+	webhooksJournalFile = "webhooks.journal.jsonl" // every change since the snapshot
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	errDeliveryNotDead  = errors.New("only dead deliveries can be retried")
+	errPrivateAddress   = errors.New("webhooks may not be sent to private, loopback or link-local addresses")
+)
+
+// Webhook is a subscription: the events in Events are POSTed to URL
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// webhookRecord is a webhook with the secret its deliveries are signed with.
+// The secret is only shown once, in the answer to the create request.
+type webhookRecord struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+func (w Webhook) wants(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEvent is the body of a delivery. For book.deleted Book is the book as it was removed.
+type webhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Book      Book      `json:"book"`
+}
+
+// Delivery is one event on its way to one webhook, with every attempt made so far
+type Delivery struct {
+	ID          int               `json:"id"`
+	WebhookID   int               `json:"webhook_id"`
+	EventID     string            `json:"event_id"`
+	Event       string            `json:"event"`
+	Status      string            `json:"status"`
+	Attempts    []deliveryAttempt `json:"attempts"`
+	Retries     int               `json:"retries,omitempty"`         // manual retries of the dead delivery, each allows MaxAttempts more
+	NextAttempt *time.Time        `json:"next_attempt_at,omitempty"` // only while pending
+	CreatedAt   time.Time         `json:"created_at"`
+	Payload     json.RawMessage   `json:"payload"` // the exact body sent, so the signature can be checked against it
+}
+
+// deliveryAttempt is one POST to the subscriber and how it went
+type deliveryAttempt struct {
+	At         time.Time `json:"at"`
+	DurationMS float64   `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Response   string    `json:"response,omitempty"` // the start of the response body
+	Error      string    `json:"error,omitempty"`    // no answer at all, e.g. a timeout
+}
+
+func (a deliveryAttempt) ok() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// webhookState is everything the dispatcher keeps, and what it writes to webhooks.json.
+// Seq is the last journal entry the snapshot includes.
+type webhookState struct {
+	Seq            uint64          `json:"seq"`
+	LastWebhookID  int             `json:"last_webhook_id"`
+	LastDeliveryID int             `json:"last_delivery_id"`
+	Webhooks       []webhookRecord `json:"webhooks"`
+	Deliveries     []Delivery      `json:"deliveries"` // oldest first
+}
+
+// webhookJournalEntry is one line of webhooks.journal.jsonl. Replaying it repeats the change,
+// see applyLocked: a webhook added or removed, or deliveries queued or changed by an attempt or a retry.
+type webhookJournalEntry struct {
+	Seq          uint64         `json:"seq"`
+	Webhook      *webhookRecord `json:"webhook,omitempty"`
+	Unsubscribed int            `json:"unsubscribed,omitempty"` // the ID of a deleted webhook
+	Deliveries   []Delivery     `json:"deliveries,omitempty"`   // the new state of each
+}
+
+// webhookConfig is read from the WEBHOOK_* settings
+type webhookConfig struct {
+	Dir          string        // where the snapshot and the journal are kept, empty keeps everything in memory only
+	MaxAttempts  int           // attempts before a delivery is dead
+	RetryBase    time.Duration // wait before the first retry, doubled for every further one
+	RetryMax     time.Duration // longest wait between two attempts
+	Timeout      time.Duration // how long a subscriber may take to answer
+	LogSize      int           // finished deliveries kept for the delivery log
+	Workers      int           // deliveries sent at once
+	AllowPrivate bool          // deliver to loopback, private and link-local addresses too
+}
+
+// webhookDispatcher queues a delivery for every book change and every webhook that wants it,
+// and sends them in the background. Failed deliveries are retried with exponential backoff
+// until MaxAttempts, then they are dead. The queue survives restarts when Dir is set:
+// every change is appended to a journal, which is compacted into webhooks.json every LogSize entries.
+// A delivery whose attempt was cut off by a restart is simply sent again.
+type webhookDispatcher struct {
+	cfg    webhookConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	state    webhookState
+	inFlight map[int]bool // deliveries being sent right now
+	journal  *os.File     // nil without Dir
+	pending  int          // journal entries since the last snapshot
+
+	wake   chan struct{} // nudges Run when something became due
+	slots  chan struct{} // one per worker
+	ctx    context.Context
+	cancel context.CancelFunc
+	sends  sync.WaitGroup
+}
+
+func newWebhookDispatcherFromConfig(cfg *appConfig) (*webhookDispatcher, error) {
+	return newWebhookDispatcher(webhookConfig{
+		Dir:          cfg.Get("WEBHOOK_DATA_DIR"),
+		MaxAttempts:  cfg.Int("WEBHOOK_MAX_ATTEMPTS"),
+		RetryBase:    cfg.Duration("WEBHOOK_RETRY_BASE"),
+		RetryMax:     cfg.Duration("WEBHOOK_RETRY_MAX"),
+		Timeout:      cfg.Duration("WEBHOOK_TIMEOUT"),
+		LogSize:      cfg.Int("WEBHOOK_LOG_SIZE"),
+		Workers:      4,
+		AllowPrivate: cfg.Bool("WEBHOOK_ALLOW_PRIVATE"),
+	})
+}
+
+// newWebhookDispatcher loads the webhooks and the queue from cfg.Dir, if it is set
+func newWebhookDispatcher(cfg webhookConfig) (*webhookDispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &webhookDispatcher{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: webhookTransport(cfg.AllowPrivate),
+			// a redirect is an answer like any other non-2xx, following it could leak the event elsewhere
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		inFlight: map[int]bool{},
+		wake:     make(chan struct{}, 1),
+		slots:    make(chan struct{}, max(cfg.Workers, 1)),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	if cfg.Dir == "" {
+		return d, nil
+	}
+	// the webhooks and their deliveries include the signing secrets in plain text, only this user may read them
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create webhook directory: %w", err)
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(filepath.Join(cfg.Dir, webhooksJournalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open webhook journal: %w", err)
+	}
+	if err := journal.Chmod(0o600); err != nil { // a journal left by an older version may be readable by everyone
+		journal.Close()
+		return nil, fmt.Errorf("could not restrict webhook journal: %w", err)
+	}
+	d.journal = journal
+	return d, nil
+}
+
+// load reads the snapshot and replays the journal entries newer than it
+func (d *webhookDispatcher) load() error {
+	data, err := os.ReadFile(filepath.Join(d.cfg.Dir, webhooksFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not read webhooks: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &d.state); err != nil {
+			return fmt.Errorf("could not decode webhooks: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(d.cfg.Dir, webhooksJournalFile), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open webhook journal: %w", err)
+	}
+	defer f.Close()
+	err = readJournal(f, func(line []byte) error {
+		var entry webhookJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		if entry.Seq <= d.state.Seq {
+			return nil // already part of the snapshot
+		}
+		d.applyLocked(entry)
+		d.state.Seq = entry.Seq
+		d.pending++
+		return nil
+	})
+	d.pruneLocked() // deliveries dropped from the log aren't journaled, they are dropped again here
+	return err
+}
+
+// Run sends the deliveries as they become due, until Close is called
+func (d *webhookDispatcher) Run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Reset(d.dispatchDue())
+		d.maybeSnapshot()
+	}
+}
+
+// Close stops the dispatcher, writes a last snapshot and closes the journal.
+// Attempts in flight are abandoned and stay pending for the next start.
+func (d *webhookDispatcher) Close() error {
+	d.cancel()
+	d.mu.Lock() // dispatchDue checks ctx under the lock, so no send starts after this
+	d.mu.Unlock()
+	d.sends.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.journal == nil {
+		return nil
+	}
+	err := d.snapshotLocked()
+	if cerr := d.journal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// webhookTransport connects to the subscribers directly, never through a proxy from the environment.
+// Unless allowPrivate is set, the dialer refuses internal addresses: otherwise anyone who may
+// create a webhook could make the server POST to its own network and read the answers in the delivery log.
+func webhookTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = refusePrivateAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// refusePrivateAddress is a net.Dialer Control hook. It sees the address actually connected to,
+// after DNS resolution, so a name that resolves (or rebinds) to an internal address is refused too.
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if isPrivateAddress(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, ip)
+	}
+	return nil
+}
+
+// internal ranges netip has no method for
+var (
+	thisNetwork   = netip.MustParsePrefix("0.0.0.0/8")
+	sharedAddress = netip.MustParsePrefix("100.64.0.0/10") // carrier-grade NAT
+)
+
+// isPrivateAddress reports whether ip belongs to this host or a private network
+func isPrivateAddress(ip netip.Addr) bool {
+	ip = ip.Unmap() // ::ffff:127.0.0.1 is 127.0.0.1
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		thisNetwork.Contains(ip) || sharedAddress.Contains(ip)
+}
+
+// dispatchDue starts every due delivery and returns how long until the next one is due
+func (d *webhookDispatcher) dispatchDue() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.ctx.Err() != nil {
+		return time.Hour // closed, Run returns right away
+	}
+	now := time.Now()
+	wait := time.Hour // nothing pending, a new delivery wakes Run up anyway
+	for _, delivery := range d.state.Deliveries {
+		if delivery.Status != deliveryPending || d.inFlight[delivery.ID] {
+			continue
+		}
+		if until := delivery.NextAttempt.Sub(now); until > 0 {
+			wait = min(wait, until)
+			continue
+		}
+		d.inFlight[delivery.ID] = true
+		d.sends.Add(1)
+		go d.deliver(delivery.ID)
+	}
+	return wait
+}
+
+// signal wakes Run up without ever blocking
+func (d *webhookDispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver makes one attempt to send a delivery and records how it went
+func (d *webhookDispatcher) deliver(id int) {
+	defer d.sends.Done()
+	select {
+	case d.slots <- struct{}{}:
+		defer func() { <-d.slots }()
+	case <-d.ctx.Done():
+		d.done(id)
+		return
+	}
+
+	d.mu.Lock()
+	var delivery Delivery
+	var hook webhookRecord
+	idx := d.deliveryIndexLocked(id)
+	hookIdx := -1
+	if idx >= 0 {
+		delivery = d.state.Deliveries[idx]
+		hookIdx = d.webhookIndexLocked(delivery.WebhookID)
+	}
+	if hookIdx >= 0 {
+		hook = d.state.Webhooks[hookIdx]
+	}
+	d.mu.Unlock()
+	if hookIdx < 0 {
+		d.done(id) // the webhook was deleted meanwhile, which dropped the delivery too
+		return
+	}
+
+	attempt := d.send(hook, delivery)
+	if d.ctx.Err() != nil {
+		d.done(id) // cut off by Close, the delivery stays pending
+		return
+	}
+
+	d.mu.Lock()
+	delete(d.inFlight, id)
+	idx = d.deliveryIndexLocked(id)
+	if idx < 0 {
+		d.mu.Unlock()
+		return
+	}
+	delivery = d.state.Deliveries[idx]
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	failed := len(delivery.Attempts) - delivery.Retries*d.cfg.MaxAttempts // since the last manual retry
+	switch {
+	case attempt.ok():
+		delivery.Status, delivery.NextAttempt = deliveryDelivered, nil
+	case failed >= d.cfg.MaxAttempts:
+		delivery.Status, delivery.NextAttempt = deliveryDead, nil
+		slog.Warn("webhook delivery is dead", "delivery", delivery.ID, "webhook", delivery.WebhookID,
+			"event", delivery.Event, "attempts", len(delivery.Attempts))
+	default:
+		next := attempt.At.Add(d.backoff(failed))
+		delivery.NextAttempt = &next
+	}
+	d.persistLocked(webhookJournalEntry{Deliveries: []Delivery{delivery}})
+	d.pruneLocked()
+	d.mu.Unlock()
+
+	d.syncJournal() // without the lock, so book changes don't wait for the disk
+	d.signal()
+}
+
+// done forgets that a delivery is being sent, without recording an attempt
+func (d *webhookDispatcher) done(id int) {
+	d.mu.Lock()
+	delete(d.inFlight, id)
+	d.mu.Unlock()
+}
+
+// backoff is the wait after the given number of failed attempts: RetryBase, twice that, four times...
+func (d *webhookDispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.RetryBase
+	for i := 1; i < attempts && wait < d.cfg.RetryMax; i++ {
+		wait *= 2
+	}
+	return min(wait, d.cfg.RetryMax)
+}
+
+// send POSTs the payload to the webhook, signed with its secret
+func (d *webhookDispatcher) send(hook webhookRecord, delivery Delivery) (attempt deliveryAttempt) {
+	start := time.Now()
+	attempt.At = start
+	defer func() { attempt.DurationMS = float64(time.Since(start).Microseconds()) / 1000 }()
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoAPI-Webhooks/1.0")
+	req.Header.Set(headerWebhookID, delivery.EventID)
+	req.Header.Set(headerWebhookEvent, delivery.Event)
+	req.Header.Set(headerWebhookTimestamp, timestamp)
+	req.Header.Set(headerWebhookSignature, "sha256="+signWebhook(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) // enough to see what went wrong
+	attempt.StatusCode, attempt.Response = resp.StatusCode, string(body)
+	return attempt
+}
+
+// signWebhook is the hex HMAC-SHA256 of "<timestamp>.<body>" with the webhook's secret.
+// Signing the timestamp too lets receivers refuse old deliveries replayed by someone else.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// onChange is the book store observer that queues the deliveries of a change
+func (d *webhookDispatcher) onChange(change bookChange) {
+	event := eventBookCreated
+	switch change.Op {
+	case opUpdate:
+		event = eventBookUpdated
+	case opDelete:
+		event = eventBookDeleted
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.state.Webhooks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	var queued []Delivery
+	lastID := d.state.LastDeliveryID
+	for _, book := range change.affected() {
+		evt := webhookEvent{ID: "evt_" + randomToken(12), Type: event, CreatedAt: now, Book: book}
+		payload, err := json.Marshal(evt)
+		if err != nil {
+			slog.Error("webhook event not queued", "event", event, "error", err)
+			continue
+		}
+		for _, hook := range d.state.Webhooks {
+			if !hook.wants(event) {
+				continue
+			}
+			lastID++
+			queued = append(queued, Delivery{
+				ID: lastID, WebhookID: hook.ID, EventID: evt.ID, Event: event,
+				Status: deliveryPending, NextAttempt: &now, CreatedAt: now, Payload: payload,
+			})
+		}
+	}
+	if len(queued) > 0 {
+		// only written, not synced: the next attempt syncs it, so a bulk import costs one write per book
+		d.persistLocked(webhookJournalEntry{Deliveries: queued})
+		d.signal()
+	}
+}
+
+// Webhooks lists the subscriptions, without their secrets
+func (d *webhookDispatcher) Webhooks() []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Webhook, len(d.state.Webhooks))
+	for i, hook := range d.state.Webhooks {
+		out[i] = hook.Webhook
+	}
+	return out
+}
+
+func (d *webhookDispatcher) Webhook(id int) (Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idx := d.webhookIndexLocked(id)
+	if idx < 0 {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return d.state.Webhooks[idx].Webhook, nil
+}
+
+// Subscribe adds a webhook with a new secret. The returned record is the only place the secret shows up.
+func (d *webhookDispatcher) Subscribe(url string, events []string) (webhookRecord, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hook := webhookRecord{
+		Webhook: Webhook{ID: d.state.LastWebhookID + 1, URL: url, Events: events, CreatedAt: time.Now()},
+		Secret:  "whsec_" + randomToken(32),
+	}
+	return hook, d.commitLocked(webhookJournalEntry{Webhook: &hook})
+}
+
+// Unsubscribe removes a webhook and drops its pending deliveries, the finished ones stay in the log
+func (d *webhookDispatcher) Unsubscribe(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.webhookIndexLocked(id) < 0 {
+		return ErrWebhookNotFound
+	}
+	return d.commitLocked(webhookJournalEntry{Unsubscribed: id})
+}
+
+// deliveryFilter picks deliveries for the log, empty fields match everything
+type deliveryFilter struct {
+	webhookID int
+	event     string
+	status    string
+	limit     int
+}
+
+// Deliveries returns the deliveries that match, newest first
+func (d *webhookDispatcher) Deliveries(filter deliveryFilter) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := []Delivery{}
+	for i := len(d.state.Deliveries) - 1; i >= 0 && len(out) < filter.limit; i-- {
+		delivery := d.state.Deliveries[i]
+		if (filter.webhookID == 0 || delivery.WebhookID == filter.webhookID) &&
+			(filter.event == "" || delivery.Event == filter.event) &&
+			(filter.status == "" || delivery.Status == filter.status) {
+			out = append(out, delivery)
+		}
+	}
+	return out
+}
+
+func (d *webhookDispatcher) Delivery(id int) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idx := d.deliveryIndexLocked(id)
+	if idx < 0 {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	return d.state.Deliveries[idx], nil
+}
+
+// Retry sends a dead delivery again, with a fresh set of attempts
+func (d *webhookDispatcher) Retry(id int) (Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idx := d.deliveryIndexLocked(id)
+	if idx < 0 {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	delivery := d.state.Deliveries[idx]
+	if delivery.Status != deliveryDead {
+		return Delivery{}, errDeliveryNotDead
+	}
+	if d.webhookIndexLocked(delivery.WebhookID) < 0 {
+		return Delivery{}, ErrWebhookNotFound
+	}
+
+	// the attempts so far stay in the log, the threshold counts from here
+	now := time.Now()
+	delivery.Status, delivery.NextAttempt = deliveryPending, &now
+	delivery.Retries++
+	if err := d.commitLocked(webhookJournalEntry{Deliveries: []Delivery{delivery}}); err != nil {
+		return Delivery{}, err
+	}
+	d.signal()
+	return delivery, nil
+}
+
+// pruneLocked drops the oldest finished deliveries beyond LogSize, pending ones are always kept.
+// Callers must hold d.mu.
+func (d *webhookDispatcher) pruneLocked() {
+	finished := 0
+	for _, delivery := range d.state.Deliveries {
+		if delivery.Status != deliveryPending {
+			finished++
+		}
+	}
+	drop := finished - d.cfg.LogSize
+	if drop <= 0 {
+		return
+	}
+	kept := d.state.Deliveries[:0]
+	for _, delivery := range d.state.Deliveries {
+		if drop > 0 && delivery.Status != deliveryPending {
+			drop--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	d.state.Deliveries = kept
+}
+
+// applyLocked makes the change an entry describes, when it happens and again when it is replayed.
+// Callers must hold d.mu.
+func (d *webhookDispatcher) applyLocked(entry webhookJournalEntry) {
+	if hook := entry.Webhook; hook != nil {
+		if idx := d.webhookIndexLocked(hook.ID); idx >= 0 {
+			d.state.Webhooks[idx] = *hook
+		} else {
+			d.state.Webhooks = append(d.state.Webhooks, *hook)
+		}
+		d.state.LastWebhookID = max(d.state.LastWebhookID, hook.ID)
+	}
+	if id := entry.Unsubscribed; id != 0 {
+		if idx := d.webhookIndexLocked(id); idx >= 0 {
+			d.state.Webhooks = append(d.state.Webhooks[:idx], d.state.Webhooks[idx+1:]...)
+		}
+		// its pending deliveries go too, the finished ones stay in the log
+		kept := d.state.Deliveries[:0]
+		for _, delivery := range d.state.Deliveries {
+			if delivery.WebhookID != id || delivery.Status != deliveryPending {
+				kept = append(kept, delivery)
+			}
+		}
+		d.state.Deliveries = kept
+	}
+	for _, delivery := range entry.Deliveries {
+		// deliveries stay in ID order, a new one has the highest ID so far and is appended
+		deliveries := d.state.Deliveries
+		idx := sort.Search(len(deliveries), func(i int) bool { return deliveries[i].ID >= delivery.ID })
+		if idx < len(deliveries) && deliveries[idx].ID == delivery.ID {
+			deliveries[idx] = delivery
+		} else {
+			d.state.Deliveries = slices.Insert(deliveries, idx, delivery)
+		}
+		d.state.LastDeliveryID = max(d.state.LastDeliveryID, delivery.ID)
+	}
+}
+
+// commitLocked journals the entry, syncs it and only then applies it, so a failure changes nothing.
+// It is used for the changes an admin asks for. Callers must hold d.mu.
+func (d *webhookDispatcher) commitLocked(entry webhookJournalEntry) error {
+	if err := d.writeLocked(&entry); err != nil {
+		return err
+	}
+	if d.journal != nil {
+		if err := d.journal.Sync(); err != nil {
+			return fmt.Errorf("could not sync webhook journal: %w", err)
+		}
+	}
+	d.applyLocked(entry)
+	return nil
+}
+
+// persistLocked journals and applies the entry where losing it only means a delivery is sent
+// again or late, so a failed write is logged and the change is made anyway. The entry is only
+// written, callers that can wait for the disk call syncJournal once they released d.mu.
+// Callers must hold d.mu.
+func (d *webhookDispatcher) persistLocked(entry webhookJournalEntry) {
+	if err := d.writeLocked(&entry); err != nil {
+		slog.Error("webhook queue not saved", "error", err)
+	}
+	d.applyLocked(entry)
+}
+
+// writeLocked appends the entry to the journal with the next sequence number. Callers must hold d.mu.
+func (d *webhookDispatcher) writeLocked(entry *webhookJournalEntry) error {
+	if d.journal == nil {
+		return nil
+	}
+	entry.Seq = d.state.Seq + 1
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := d.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write webhook journal: %w", err)
+	}
+	d.state.Seq++
+	d.pending++
+	return nil
+}
+
+// syncJournal flushes the entries written so far to disk
+func (d *webhookDispatcher) syncJournal() {
+	if d.journal == nil {
+		return
+	}
+	if err := d.journal.Sync(); err != nil {
+		slog.Error("webhook queue not saved", "error", err)
+	}
+}
+
+// maybeSnapshot compacts the journal once it has as many entries as the log keeps deliveries,
+// so the cost of writing the whole state is spread over that many changes
+func (d *webhookDispatcher) maybeSnapshot() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.journal == nil || d.pending < d.cfg.LogSize || d.ctx.Err() != nil {
+		return // Close writes the last snapshot
+	}
+	if err := d.snapshotLocked(); err != nil {
+		// every change is still in the journal, so only the compaction failed
+		slog.Error("webhook queue snapshot failed", "error", err)
+	}
+}
+
+// snapshotLocked writes the whole state to webhooks.json and empties the journal.
+// If we crash in between, load skips the journal entries the snapshot's seq covers.
+// Callers must hold d.mu.
+func (d *webhookDispatcher) snapshotLocked() error {
+	data, err := json.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(d.cfg.Dir, webhooksFile, data); err != nil {
+		return err
+	}
+	if err := d.journal.Truncate(0); err != nil {
+		return err
+	}
+	d.pending = 0
+	return nil
+}
+
+// webhookIndexLocked returns the position of the webhook or -1. Callers must hold d.mu.
+func (d *webhookDispatcher) webhookIndexLocked(id int) int {
+	for i, hook := range d.state.Webhooks {
+		if hook.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// deliveryIndexLocked returns the position of the delivery or -1. Callers must hold d.mu.
+// Deliveries are kept in ID order, so it can search.
+func (d *webhookDispatcher) deliveryIndexLocked(id int) int {
+	deliveries := d.state.Deliveries
+	idx := sort.Search(len(deliveries), func(i int) bool { return deliveries[i].ID >= id })
+	if idx < len(deliveries) && deliveries[idx].ID == id {
+		return idx
+	}
+	return -1
+}
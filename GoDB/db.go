@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
 )
 
 type Product struct {
@@ -11,10 +15,10 @@ type Product struct {
 	Price int `json:"price"`
 }
 
-func createProduct(product *Product) error {
+func createProduct(ctx context.Context, product *Product) error {
 	// Insert product into the database
 	// query := `INSERT INTO products (name, price) VALUES ($1, $2) RETURNING id`
-	_ , err := db.Exec("INSERT INTO products (name, price) VALUES ($1, $2)", product.Name, product.Price)
+	_ , err := exec(ctx, "INSERT INTO products (name, price) VALUES ($1, $2)", product.Name, product.Price)
 	if err != nil {
 		return fmt.Errorf("could not create product: %v", err)
 	}
@@ -22,10 +26,10 @@ func createProduct(product *Product) error {
 
 }
 
-func getProductByID(id int) (*Product, error) {
+func getProductByID(ctx context.Context, id int) (*Product, error) {
 	var product Product
 	// query := `SELECT id, name, price FROM products WHERE id = $1`
-	row := db.QueryRow("SELECT id, name, price FROM products WHERE id = $1", id)
+	row := queryRow(ctx, "SELECT id, name, price FROM products WHERE id = $1", id)
 	err := row.Scan(&product.ID, &product.Name, &product.Price)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -36,10 +40,10 @@ func getProductByID(id int) (*Product, error) {
 	return &product, nil
 }
 
-func getAllProducts() ([]Product, error) {
+func getAllProducts(ctx context.Context) ([]Product, error) {
 	var products []Product
 	// query := `SELECT id, name, price FROM products`
-	rows, err := db.Query("SELECT id, name, price FROM products")
+	rows, err := query(ctx, "SELECT id, name, price FROM products")
 	if err != nil {
 		return nil, fmt.Errorf("could not get products: %v", err)
 	}
@@ -60,22 +64,48 @@ func getAllProducts() ([]Product, error) {
 	return products, nil
 }
 
-func updateProduct(id int, product *Product) error {
+func updateProduct(ctx context.Context, id int, product *Product) error {
 	// Update product in the database
 	// query := `UPDATE products SET name = $1, price = $2 WHERE id = $3`
-	_, err := db.Exec("UPDATE products SET name = $1, price = $2 WHERE id = $3", product.Name, product.Price, id)
+	_, err := exec(ctx, "UPDATE products SET name = $1, price = $2 WHERE id = $3", product.Name, product.Price, id)
 	if err != nil {
 		return fmt.Errorf("could not update product: %v", err)
 	}
 	return nil
 }
 
-func deleteProduct(id int) error {
+func deleteProduct(ctx context.Context, id int) error {
 	// Delete product from the database
 	// query := `DELETE FROM products WHERE id = $1`
-	_, err := db.Exec("DELETE FROM products WHERE id = $1", id)
+	_, err := exec(ctx, "DELETE FROM products WHERE id = $1", id)
 	if err != nil {
 		return fmt.Errorf("could not delete product: %v", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// exec, query and queryRow run a statement for the request in ctx and log it with the request ID, see reqlog.Query
+func exec(ctx context.Context, sqlText string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	result, err := db.ExecContext(ctx, sqlText, args...)
+	rows := int64(-1)
+	if err == nil {
+		rows, _ = result.RowsAffected()
+	}
+	reqlog.Query(ctx, sqlText, time.Since(start), rows, err)
+	return result, err
+}
+
+func query(ctx context.Context, sqlText string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, sqlText, args...)
+	reqlog.Query(ctx, sqlText, time.Since(start), -1, err) // the rows aren't read yet
+	return rows, err
+}
+
+func queryRow(ctx context.Context, sqlText string, args ...any) *sql.Row {
+	start := time.Now()
+	row := db.QueryRowContext(ctx, sqlText, args...)
+	reqlog.Query(ctx, sqlText, time.Since(start), -1, row.Err())
+	return row
+}
@@ -1,29 +1,38 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/lib/pq" // PostgreSQL driver
-	"fmt"
 	"log"
 	"github.com/gofiber/fiber/v2"
 	"strconv"
+	"time"
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/RookieJoel/GoAPI-essential/shared/graceful"
+	"github.com/RookieJoel/GoAPI-essential/shared/health"
+	"github.com/RookieJoel/GoAPI-essential/shared/metrics"
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
 )
 
-const (
-	host = "localhost"
-	port = 5433
-	dbname = "mydatabase"
-	user = "myuser"
-	password = "mypassword"
-)
+// settings are read from flags, the environment and .env, see shared/config.
+// The database credentials have no default, put them in .env (DB_USER=myuser, DB_PASSWORD=mypassword)
+var settings = append(append([]config.Setting{
+	{Name: "PORT", Type: config.Int, Default: "8080", Min: 1, Max: 65535, Description: "port the server listens on"},
+	{Name: "SHUTDOWN_TIMEOUT", Type: config.Duration, Default: "10s", Description: "how long requests in flight may finish after SIGTERM"},
+	{Name: "SHUTDOWN_DELAY", Type: config.Duration, Description: "how long /readyz fails before the server stops accepting connections on SIGTERM"},
+}, config.Postgres...), reqlog.Settings...)
 
 var db *sql.DB
 
 func main() { 
+  // stops here with a list of every invalid or missing setting
+  cfg := config.MustLoad(settings, ".env")
+  // JSON lines on stdout, LOG_LEVEL=debug adds every query
+  logger := reqlog.Setup(cfg)
+
 	// Connection string
-  psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
-    "password=%s dbname=%s sslmode=disable",
-    host, port, user, password, dbname)
+  psqlInfo := cfg.PostgresDSN()
 
   // Open a connection
   sdb, err := sql.Open("postgres", psqlInfo)
@@ -31,17 +40,36 @@ func main() {
     log.Fatal(err)
   }
   db = sdb
-  defer db.Close()
-  // Check the connection
-  err = db.Ping()
+  // Check the connection, the database may still be starting (docker compose up)
+  ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration("DB_CONNECT_TIMEOUT"))
+  err = health.WaitFor(ctx, health.Ping(db), time.Second)
+  cancel()
   if err != nil {
-    log.Fatal(err)
+    log.Fatalf("Database not reachable after %s: %v", cfg.Get("DB_CONNECT_TIMEOUT"), err)
   }
 
-  fmt.Println("Successfully connected!")
+  log.Println("Successfully connected!")
 
   app := fiber.New()
 
+  // one log line per request, tagged with its X-Request-ID like the queries it ran
+  app.Use(reqlog.Middleware(logger, nil))
+
+  // requests per route, latencies and the connection pool (go_sql_*), scraped by Prometheus from /metrics
+  stats := metrics.New()
+  stats.RegisterDB(cfg.Get("DB_NAME"), db)
+  app.Use(stats.Middleware())
+  app.Get("/metrics", stats.Handler())
+
+  // probes: /healthz while the process runs, /readyz while the database answers too
+  checks := health.New(health.DefaultTimeout)
+  checks.Register("postgres", health.Ping(db))
+  app.Get("/healthz", checks.Live)
+  app.Get("/readyz", checks.Ready)
+
+  // close the connection pool on shutdown, after the requests in flight are done with it
+  app.Hooks().OnShutdown(db.Close)
+
   app.Get("/", func(c *fiber.Ctx) error {
 	return c.SendString("Welcome to the GoDB API!")
   }) 
@@ -61,7 +89,11 @@ func main() {
   // delete a product using Fiber
   app.Delete("/products/:id", deleteProductsHandler)
 
-  app.Listen(":8080")
+  err = graceful.ListenAndServe(app, ":"+cfg.Get("PORT"), cfg.Duration("SHUTDOWN_TIMEOUT"),
+    graceful.OnStop(checks.Drain), graceful.Delay(cfg.Duration("SHUTDOWN_DELAY")))
+  if err != nil {
+    log.Fatalf("Server stopped: %v", err)
+  }
 
 //   //create a product
 //   err = createProduct(&Product{
@@ -118,7 +150,7 @@ func main() {
 }
 
 func getAllProductsGHandler(c *fiber.Ctx) error {
-	products, err := getAllProducts()
+	products, err := getAllProducts(c.UserContext())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
@@ -132,7 +164,7 @@ func getProductsHandler(c *fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
 
-	product , err := getProductByID(pid)
+	product , err := getProductByID(c.UserContext(), pid)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).SendString(err.Error())
 	}
@@ -145,7 +177,7 @@ func createProductsHandler(c *fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
 
-	if err := createProduct(p); err != nil {
+	if err := createProduct(c.UserContext(), p); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
@@ -163,7 +195,7 @@ func updateProductsHandler(c *fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
 
-	if err := updateProduct(pid, p); err != nil {
+	if err := updateProduct(c.UserContext(), pid, p); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
@@ -175,7 +207,7 @@ func deleteProductsHandler(c *fiber.Ctx) error {
 	if err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
-	if err := deleteProduct(pid); err != nil {
+	if err := deleteProduct(c.UserContext(), pid); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 	return c.SendStatus(fiber.StatusNoContent) // 204 No Content
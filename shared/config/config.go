@@ -0,0 +1,337 @@
+// Package config loads the settings of a server from four layers, each one overriding the one before:
+// the built-in defaults, an optional .env file, the environment and command-line flags.
+//
+// Every setting is declared with its type, so a value is checked when it is loaded and not when
+// some handler first reads it. All problems are reported together: a bad deployment fails at startup
+// with one list instead of one error per restart.
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// where a value came from, in order of precedence
+const (
+	SourceDefault = "default" // the built-in default
+	SourceDotEnv  = ".env"    // the .env file
+	SourceEnv     = "env"     // the process environment
+	SourceFlag    = "flag"    // the command line
+)
+
+// Type is how a setting's value is parsed and checked
+type Type int
+
+const (
+	String   Type = iota
+	Int           // a whole number between Min and Max
+	Duration      // a positive duration such as 15m or 24h
+	Bool          // true or false (also 1/0, t/f)
+)
+
+// Setting declares one configuration key. The flag of a setting is its name in lower case
+// with dashes, e.g. JWT_KEY_DIR is -jwt-key-dir.
+type Setting struct {
+	Name     string
+	Type     Type
+	Default  string
+	Required bool // some layer must give a non-empty value
+	Secret   bool // never shown, only its fingerprint
+	// Options are the allowed values of a String, empty allows any
+	Options []string
+	// Min and Max bound an Int. Max 0 means no upper bound.
+	Min, Max    int
+	Description string
+}
+
+// Flag is the command-line flag of the setting
+func (s Setting) Flag() string {
+	return strings.ReplaceAll(strings.ToLower(s.Name), "_", "-")
+}
+
+// value is a resolved setting
+type value struct {
+	Setting
+	raw    string
+	source string
+}
+
+// Config is the effective configuration and the origin of every value
+type Config struct {
+	values map[string]value
+}
+
+// Rule checks settings against each other, e.g. that one duration is at least another.
+// Rules only run when every setting is valid on its own.
+type Rule func(cfg *Config) error
+
+// Problem is one invalid or missing setting
+type Problem struct {
+	Name    string // the setting, empty for problems with the command line or the .env file itself
+	Source  string
+	Message string
+}
+
+// Report lists every problem found while loading, it is the error Load returns
+type Report []Problem
+
+func (r Report) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problem", len(r))
+	if len(r) != 1 {
+		b.WriteString("s")
+	}
+	b.WriteString("):")
+	for _, p := range r {
+		b.WriteString("\n  ")
+		if p.Name != "" {
+			b.WriteString(p.Name + ": ")
+		}
+		b.WriteString(p.Message)
+		if p.Source != "" && p.Source != SourceDefault {
+			b.WriteString(" (from " + p.Source + ")")
+		}
+	}
+	return b.String()
+}
+
+// Load resolves every setting from the defaults, envFile (skipped if it doesn't exist),
+// the environment and the flags in args, in that order of precedence, then validates them.
+// The error is a Report listing every problem, or flag.ErrHelp when args ask for -h.
+func Load(settings []Setting, envFile string, args []string, rules ...Rule) (*Config, error) {
+	var report Report
+
+	fileValues := map[string]string{}
+	if envFile != "" {
+		var err error
+		fileValues, err = godotenv.Read(envFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			fileValues = map[string]string{} // the .env file is optional
+		} else if err != nil {
+			report = append(report, Problem{Source: SourceDotEnv, Message: "cannot read " + envFile + ": " + err.Error()})
+		}
+	}
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard) // problems go into the report, -h prints Usage
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.Name] = flags.String(s.Flag(), "", s.Description)
+	}
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	} else if err != nil {
+		report = append(report, Problem{Source: SourceFlag, Message: err.Error()})
+	}
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	cfg := &Config{values: make(map[string]value, len(settings))}
+	for _, s := range settings {
+		v := value{Setting: s, raw: s.Default, source: SourceDefault}
+		if file, ok := fileValues[s.Name]; ok {
+			v.raw, v.source = file, SourceDotEnv
+		}
+		if env, ok := os.LookupEnv(s.Name); ok {
+			v.raw, v.source = env, SourceEnv
+		}
+		if given[s.Flag()] {
+			v.raw, v.source = *flagValues[s.Name], SourceFlag
+		}
+		v.raw = strings.TrimSpace(v.raw)
+		cfg.values[s.Name] = v
+
+		if message := v.check(); message != "" {
+			report = append(report, Problem{Name: s.Name, Source: v.source, Message: message})
+		}
+	}
+
+	if len(report) == 0 {
+		for _, rule := range rules {
+			if err := rule(cfg); err != nil {
+				report = append(report, Problem{Message: err.Error()})
+			}
+		}
+	}
+	if len(report) > 0 {
+		return nil, report
+	}
+	return cfg, nil
+}
+
+// MustLoad loads the configuration from the .env file, the environment and os.Args.
+// On any problem it prints the report and exits with status 2, and -h prints the settings.
+func MustLoad(settings []Setting, envFile string, rules ...Rule) *Config {
+	cfg, err := Load(settings, envFile, os.Args[1:], rules...)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, Usage(settings))
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// check validates the value against its type, it returns what is wrong or ""
+func (v value) check() string {
+	if v.raw == "" {
+		if v.Required {
+			return "is required, " + v.Description
+		}
+		return "" // optional and not set
+	}
+	shown := strconv.Quote(v.raw)
+	if v.Secret {
+		shown = "the value"
+	}
+	switch v.Type {
+	case String:
+		if len(v.Options) > 0 && !slices.Contains(v.Options, v.raw) {
+			return shown + " is not one of " + strings.Join(v.Options, ", ")
+		}
+	case Int:
+		n, err := strconv.Atoi(v.raw)
+		if err != nil {
+			return shown + " is not a whole number"
+		}
+		if n < v.Min || (v.Max != 0 && n > v.Max) {
+			if v.Max != 0 {
+				return fmt.Sprintf("%s is not between %d and %d", shown, v.Min, v.Max)
+			}
+			return fmt.Sprintf("%s is less than %d", shown, v.Min)
+		}
+	case Duration:
+		d, err := time.ParseDuration(v.raw)
+		if err != nil || d <= 0 {
+			return shown + " is not a positive duration such as 30s, 15m or 24h"
+		}
+	case Bool:
+		if _, err := strconv.ParseBool(v.raw); err != nil {
+			return shown + " is not true or false"
+		}
+	}
+	return ""
+}
+
+// lookup returns the value of a declared setting
+func (cfg *Config) lookup(name string) value {
+	v, ok := cfg.values[name]
+	if !ok {
+		panic("config: unknown setting " + name) // every key must be declared
+	}
+	return v
+}
+
+// Get returns the effective value of a setting
+func (cfg *Config) Get(name string) string {
+	return cfg.lookup(name).raw
+}
+
+// Int returns the value of an Int setting, 0 if it is optional and not set
+func (cfg *Config) Int(name string) int {
+	n, _ := strconv.Atoi(cfg.lookup(name).raw) // checked by Load
+	return n
+}
+
+// Duration returns the value of a Duration setting, 0 if it is optional and not set
+func (cfg *Config) Duration(name string) time.Duration {
+	d, _ := time.ParseDuration(cfg.lookup(name).raw) // checked by Load
+	return d
+}
+
+// Bool returns the value of a Bool setting, false if it is optional and not set
+func (cfg *Config) Bool(name string) bool {
+	b, _ := strconv.ParseBool(cfg.lookup(name).raw) // checked by Load
+	return b
+}
+
+// Source tells which layer the value of a setting came from
+func (cfg *Config) Source(name string) string {
+	return cfg.lookup(name).source
+}
+
+// Entry is one setting as shown to operators, with a secret replaced by its fingerprint
+type Entry struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Secret      bool   `json:"secret"`
+	Source      string `json:"source"`
+	Description string `json:"description"`
+}
+
+// Entries lists the effective configuration sorted by name
+func (cfg *Config) Entries() []Entry {
+	entries := make([]Entry, 0, len(cfg.values))
+	for _, v := range cfg.values {
+		entry := Entry{
+			Name:        v.Name,
+			Secret:      v.Secret,
+			Source:      v.source,
+			Description: v.Description,
+		}
+		if v.Secret {
+			entry.Fingerprint = Fingerprint(v.raw)
+		} else {
+			entry.Value = v.raw
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// fingerprintKey keys the fingerprints, it is made anew by every process
+var fingerprintKey = func() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic("config: no randomness for the fingerprint key: " + err.Error())
+	}
+	return key
+}()
+
+// Fingerprint identifies a secret without revealing it, e.g. to tell whether an instance picked up a new password.
+// It is an HMAC with a key only this process knows, so it can't be reversed by hashing guessed passwords offline;
+// fingerprints can be compared while the process runs, not across restarts or instances.
+func Fingerprint(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write([]byte(secret))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Usage describes every setting with its flag, variable and default, for -h
+func Usage(settings []Setting) string {
+	var b strings.Builder
+	b.WriteString("Settings come from flags, then environment variables, then the .env file, then the defaults.\n\n")
+	for _, s := range settings {
+		fmt.Fprintf(&b, "  -%s, %s\n    \t%s", s.Flag(), s.Name, s.Description)
+		switch {
+		case s.Required:
+			b.WriteString(" (required)")
+		case s.Default != "" && !s.Secret:
+			b.WriteString(" (default " + s.Default + ")")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint("") != "" {
+		t.Error("an unset secret has a fingerprint")
+	}
+	a, b := Fingerprint("password"), Fingerprint("password1")
+	if a != Fingerprint("password") || a == b {
+		t.Errorf("fingerprints %q and %q don't tell the secrets apart", a, b)
+	}
+	// guessing passwords and hashing them must not find the secret
+	sum := sha256.Sum256([]byte("password"))
+	if strings.Contains(hex.EncodeToString(sum[:]), strings.TrimPrefix(a, "hmac-sha256:")) {
+		t.Errorf("fingerprint %q is a plain hash of the secret", a)
+	}
+}
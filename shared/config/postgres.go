@@ -0,0 +1,33 @@
+package config
+
+import "strings"
+
+// Postgres are the connection settings of the GoDB and GORM servers.
+// The defaults match the docker-compose database, the credentials have to be configured.
+var Postgres = []Setting{
+	{Name: "DB_HOST", Default: "localhost", Description: "PostgreSQL host"},
+	{Name: "DB_PORT", Type: Int, Default: "5433", Min: 1, Max: 65535, Description: "PostgreSQL port"},
+	{Name: "DB_NAME", Default: "mydatabase", Description: "database name"},
+	{Name: "DB_USER", Required: true, Description: "database user"},
+	{Name: "DB_PASSWORD", Required: true, Secret: true, Description: "password of the database user"},
+	{Name: "DB_CONNECT_TIMEOUT", Type: Duration, Default: "30s", Description: "how long to wait for the database at startup"},
+	{Name: "DB_SSLMODE", Default: "disable", Options: []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}, Description: "TLS to the database: disable, require, verify-full, ..."},
+}
+
+// PostgresDSN builds the key=value connection string from the Postgres settings
+func (cfg *Config) PostgresDSN() string {
+	parts := []string{
+		"host=" + dsnValue(cfg.Get("DB_HOST")),
+		"port=" + cfg.Get("DB_PORT"),
+		"user=" + dsnValue(cfg.Get("DB_USER")),
+		"password=" + dsnValue(cfg.Get("DB_PASSWORD")),
+		"dbname=" + dsnValue(cfg.Get("DB_NAME")),
+		"sslmode=" + cfg.Get("DB_SSLMODE"),
+	}
+	return strings.Join(parts, " ")
+}
+
+// dsnValue quotes a value for a key=value connection string, so a password may contain spaces or quotes
+func dsnValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
@@ -0,0 +1,107 @@
+// Package graceful serves a Fiber app until the process is asked to stop, then shuts it down in order:
+//
+//  1. run the OnStop functions, e.g. making the readiness probe fail, and keep serving for the Delay
+//     so the load balancer notices and stops sending new requests
+//  2. stop accepting connections (the listener is closed, so a load balancer sees the port go away)
+//  3. let the requests in flight finish, for at most the drain deadline
+//  4. run the app's OnShutdown hooks, which close database pools and flush pending writes
+//
+// The hooks run even when the deadline passes, so data is never left unflushed because one
+// client was slow. A second signal skips the wait and exits at once.
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultTimeout is the drain deadline used when none is configured
+const DefaultTimeout = 10 * time.Second
+
+// Option changes how ListenAndServe stops
+type Option func(*options)
+
+type options struct {
+	onStop []func()
+	delay  time.Duration
+}
+
+// OnStop runs fn as soon as the stop signal arrives, while the server still accepts connections
+func OnStop(fn func()) Option {
+	return func(o *options) { o.onStop = append(o.onStop, fn) }
+}
+
+// Delay keeps accepting connections for d after the stop signal. Behind a load balancer that polls
+// readiness, d should be a little longer than its polling interval. The drain deadline starts after d.
+func Delay(d time.Duration) Option {
+	return func(o *options) { o.delay = d }
+}
+
+// ListenAndServe serves app on addr until SIGINT or SIGTERM and then shuts it down within timeout.
+// It returns nil after a clean shutdown, and an error if the server couldn't start
+// or requests were still running when the deadline passed.
+func ListenAndServe(app *fiber.App, addr string, timeout time.Duration, opts ...Option) error {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// answer "Connection: close" while draining, so keep-alive clients reconnect elsewhere
+	app.Server().CloseOnShutdown = true
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() { served <- app.Listen(addr) }()
+
+	select {
+	case err := <-served:
+		// the server never started (e.g. the port is taken), still release what the app holds
+		if shutdownErr := app.Shutdown(); shutdownErr != nil {
+			log.Printf("shutdown: %v", shutdownErr)
+		}
+		return err
+	case sig := <-signals:
+		log.Printf("%s received, draining requests for up to %s", sig, timeout)
+	}
+
+	go func() {
+		sig := <-signals
+		log.Printf("%s received again, exiting without waiting", sig)
+		os.Exit(1)
+	}()
+
+	for _, fn := range o.onStop {
+		fn()
+	}
+	if o.delay > 0 {
+		log.Printf("not ready any more, still accepting connections for %s so the load balancer can take this server out", o.delay)
+		time.Sleep(o.delay)
+	}
+
+	start := time.Now()
+	err := app.ShutdownWithTimeout(timeout) // the OnShutdown hooks have run when this returns
+	if serveErr := <-served; serveErr != nil && err == nil {
+		err = serveErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("requests still running after %s were cut off", timeout)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("shut down cleanly in %s", time.Since(start).Round(time.Millisecond))
+	return nil
+}
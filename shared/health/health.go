@@ -0,0 +1,197 @@
+// Package health answers the liveness and readiness probes of a server.
+//
+// GET /healthz (liveness) only tells that the process serves requests; it never looks at dependencies,
+// so a database outage doesn't get every instance restarted. GET /readyz (readiness) runs the registered
+// checks, e.g. a database ping, and answers 503 when one fails, so the load balancer sends traffic elsewhere
+// until it recovers. Once the server starts shutting down readiness fails for good.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultTimeout bounds each check when no timeout is given to New
+const DefaultTimeout = 2 * time.Second
+
+// states of a check and of the whole report
+const (
+	StatusOK           = "ok"
+	StatusFailing      = "failing"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Check reports whether a dependency is usable. It should give up when ctx is done.
+type Check func(ctx context.Context) error
+
+// Checker holds the readiness checks of a server
+type Checker struct {
+	timeout  time.Duration
+	mu       sync.Mutex
+	names    []string
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+func New(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout, checks: map[string]Check{}}
+}
+
+// Register adds a readiness check, reported under name
+func (h *Checker) Register(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// Drain makes readiness fail from now on, it is called when the server starts shutting down
+func (h *Checker) Drain() {
+	h.draining.Store(true)
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status     string  `json:"status"` // ok or failing
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Report is the body of /readyz
+type Report struct {
+	Status string            `json:"status"` // ok, failing or shutting_down
+	Checks map[string]Result `json:"checks"`
+}
+
+// Run runs every check at once, each within the timeout
+func (h *Checker) Run(ctx context.Context) Report {
+	h.mu.Lock()
+	names := append([]string(nil), h.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.Unlock()
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = h.run(ctx, checks[i])
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFailing
+		}
+	}
+	if h.draining.Load() {
+		report.Status = StatusShuttingDown
+	}
+	return report
+}
+
+// run runs one check. A check that ignores its context is abandoned when the timeout passes.
+func (h *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("check panicked: %v", r)
+			}
+		}()
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("no answer within %s", h.timeout)
+	}
+	result := Result{Status: StatusOK, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status, result.Error = StatusFailing, err.Error()
+	}
+	return result
+}
+
+// Live handles GET /healthz: 200 as long as the server answers at all
+func (h *Checker) Live(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": StatusOK})
+}
+
+// Ready handles GET /readyz: 200 with every check ok, 503 otherwise, with the result of each check
+func (h *Checker) Ready(c *fiber.Ctx) error {
+	report := h.Run(c.UserContext())
+	status := fiber.StatusOK
+	if report.Status != StatusOK {
+		status = fiber.StatusServiceUnavailable
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(status).JSON(report)
+}
+
+// WaitFor runs check every interval until it passes, e.g. to wait for the database at startup.
+// It gives up with the last error when ctx is done.
+func WaitFor(ctx context.Context, check Check, interval time.Duration) error {
+	for {
+		err := check(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Ping checks that the database answers
+func Ping(db *sql.DB) Check {
+	return db.PingContext
+}
+
+// Writable checks that files can be created in dir, e.g. the directory a store writes its data to
+func Writable(dir string) Check {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			var pathErr *fs.PathError // its message has the random file name, the directory says more
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			return fmt.Errorf("cannot create files in %s: %w", dir, err)
+		}
+		name := f.Name()
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Remove(name)
+	}
+}
@@ -0,0 +1,96 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// JWK is the public half of a key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of every key that may still verify a token
+func (r *Ring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for kid, pub := range r.VerificationKeys() {
+		jwk, err := publicJWK(pub.(crypto.PublicKey))
+		if err != nil {
+			continue
+		}
+		jwk.Kid, jwk.Use, jwk.Alg = kid, "sig", r.cfg.Alg
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// Handler serves the JWKS. Verifiers may cache it for a few minutes, not longer than the overlap.
+func (r *Ring) Handler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(r.JWKS())
+}
+
+// publicJWK fills in the key type specific members
+func publicJWK(pub crypto.PublicKey) (JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}, nil
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(k)}, nil
+	}
+	return JWK{}, errUnsupportedKey
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint of a public key, used as its kid.
+// The required members are hashed in lexicographic order without whitespace.
+func thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+
+	var canonical []byte
+	switch jwk.Kty {
+	case "RSA":
+		canonical, err = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N})
+	case "OKP":
+		canonical, err = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X})
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,331 @@
+// Package keyring signs JWTs with asymmetric keys (RS256 or EdDSA) and rotates them.
+//
+// Every key has a kid (its RFC 7638 thumbprint) that is written into the token header.
+// After a rotation the previous keys keep verifying tokens for an overlap window,
+// and all of them are published as a JWKS so other services can verify our tokens
+// without sharing a secret.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// supported signing algorithms
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// Config controls the algorithm, where keys are kept and how often they rotate
+type Config struct {
+	// Alg is RS256 or EdDSA. Default: RS256
+	Alg string
+	// Dir keeps the private keys as PKCS#8 PEM files so tokens survive a restart.
+	// Empty means keys only live in memory.
+	Dir string
+	// RotateEvery is how long a key signs new tokens. Default: 24h
+	RotateEvery time.Duration
+	// Overlap is how long a replaced key keeps verifying tokens,
+	// it must be at least the lifetime of the tokens it signed. Default: 1h
+	Overlap time.Duration
+}
+
+// key is one signing key of the ring
+type key struct {
+	kid     string
+	private crypto.Signer
+	created time.Time
+	retired time.Time // zero while the key is the active one
+}
+
+// Ring holds the active signing key and the recently retired ones
+type Ring struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	active   *key
+	previous []*key // newest first
+	onRotate []func()
+}
+
+// New loads the keys found in cfg.Dir (if any) and makes sure there is a fresh active key
+func New(cfg Config) (*Ring, error) {
+	if cfg.Alg == "" {
+		cfg.Alg = RS256
+	}
+	if cfg.Alg != RS256 && cfg.Alg != EdDSA {
+		return nil, fmt.Errorf("keyring: unsupported algorithm %q (want %s or %s)", cfg.Alg, RS256, EdDSA)
+	}
+	if cfg.RotateEvery <= 0 {
+		cfg.RotateEvery = 24 * time.Hour
+	}
+	if cfg.Overlap <= 0 {
+		cfg.Overlap = time.Hour
+	}
+
+	r := &Ring{cfg: cfg}
+	if cfg.Dir != "" {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+	if r.active == nil || time.Since(r.active.created) >= cfg.RotateEvery {
+		if err := r.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Alg is the JWT "alg" of every token the ring signs
+func (r *Ring) Alg() string {
+	return r.cfg.Alg
+}
+
+// method is the jwt signing method matching the configured algorithm
+func (r *Ring) method() jwt.SigningMethod {
+	if r.cfg.Alg == EdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// Sign signs the claims with the active key and puts its kid into the header
+func (r *Ring) Sign(claims jwt.Claims) (string, error) {
+	r.mu.RLock()
+	active := r.active
+	r.mu.RUnlock()
+
+	token := jwt.NewWithClaims(r.method(), claims)
+	token.Header["kid"] = active.kid
+	return token.SignedString(active.private)
+}
+
+// Keyfunc finds the public key for a token by its kid, for use with jwt.Parse
+func (r *Ring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != r.cfg.Alg {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	if pub, ok := r.VerificationKeys()[kid]; ok {
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// VerificationKeys maps every kid that may still appear in a valid token to its public key
+func (r *Ring) VerificationKeys() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := map[string]interface{}{r.active.kid: r.active.private.Public()}
+	for _, k := range r.previous {
+		keys[k.kid] = k.private.Public()
+	}
+	return keys
+}
+
+// OnRotate registers a callback that runs after every rotation and whenever an expired key
+// is dropped, e.g. to rebuild a middleware that was given a fixed set of keys
+func (r *Ring) OnRotate(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onRotate = append(r.onRotate, fn)
+}
+
+// Rotate generates a new active key. The old one keeps verifying for the overlap window.
+func (r *Ring) Rotate() error {
+	private, err := generate(r.cfg.Alg)
+	if err != nil {
+		return err
+	}
+	kid, err := thumbprint(private.Public())
+	if err != nil {
+		return err
+	}
+	k := &key{kid: kid, private: private, created: time.Now()}
+	if r.cfg.Dir != "" {
+		if err := r.save(k); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	if r.active != nil {
+		r.active.retired = k.created
+		r.previous = append([]*key{r.active}, r.previous...)
+	}
+	r.active = k
+	r.pruneLocked()
+	r.notifyUnlock()
+	return nil
+}
+
+// Run rotates the key every RotateEvery and drops expired keys until stop is closed
+func (r *Ring) Run(stop <-chan struct{}) {
+	check := min(time.Minute, r.cfg.RotateEvery/4)
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := r.tick(); err != nil {
+				slog.Error("key rotation failed", "error", err) // the current key keeps signing, the next tick tries again
+			}
+		}
+	}
+}
+
+// tick rotates the key when it is due, otherwise it drops the keys whose overlap window is over
+func (r *Ring) tick() error {
+	r.mu.RLock()
+	due := time.Since(r.active.created) >= r.cfg.RotateEvery
+	r.mu.RUnlock()
+	if due {
+		return r.Rotate()
+	}
+
+	r.mu.Lock()
+	if !r.pruneLocked() {
+		r.mu.Unlock()
+		return nil
+	}
+	r.notifyUnlock() // a key stopped verifying, so must the middleware built with it
+	return nil
+}
+
+// notifyUnlock releases r.mu and runs the OnRotate callbacks. Callers must hold r.mu.
+func (r *Ring) notifyUnlock() {
+	callbacks := append([]func(){}, r.onRotate...)
+	r.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// pruneLocked forgets keys whose overlap window is over and reports whether there were any.
+// Callers must hold r.mu.
+func (r *Ring) pruneLocked() bool {
+	kept := r.previous[:0]
+	for _, k := range r.previous {
+		if time.Since(k.retired) < r.cfg.Overlap {
+			kept = append(kept, k)
+			continue
+		}
+		if r.cfg.Dir != "" {
+			os.Remove(r.path(k.kid))
+		}
+	}
+	pruned := len(kept) < len(r.previous)
+	r.previous = kept
+	return pruned
+}
+
+func generate(alg string) (crypto.Signer, error) {
+	if alg == EdDSA {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+func (r *Ring) path(kid string) string {
+	return filepath.Join(r.cfg.Dir, kid+".pem")
+}
+
+// save writes the private key as PKCS#8 PEM, readable only by us
+func (r *Ring) save(k *key) error {
+	if err := os.MkdirAll(r.cfg.Dir, 0o700); err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return os.WriteFile(r.path(k.kid), data, 0o600)
+}
+
+// load reads the keys of the configured algorithm from Dir.
+// The newest one becomes active, each older key is retired when the next one was created.
+func (r *Ring) load() error {
+	files, err := filepath.Glob(filepath.Join(r.cfg.Dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	var keys []*key
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("keyring: %s is not a PEM file", file)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("keyring: %s: %w", file, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok || algOf(signer) != r.cfg.Alg {
+			continue // a key for another algorithm, e.g. before JWT_ALG was changed
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		kid, err := thumbprint(signer.Public())
+		if err != nil {
+			return err
+		}
+		if strings.TrimSuffix(filepath.Base(file), ".pem") != kid {
+			return fmt.Errorf("keyring: %s does not match its key id %s", file, kid)
+		}
+		keys = append(keys, &key{kid: kid, private: signer, created: info.ModTime()})
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].created.After(keys[j].created) })
+	for i := 1; i < len(keys); i++ {
+		keys[i].retired = keys[i-1].created
+	}
+	r.active, r.previous = keys[0], keys[1:]
+	r.pruneLocked()
+	return nil
+}
+
+func algOf(signer crypto.Signer) string {
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		return RS256
+	case ed25519.PrivateKey:
+		return EdDSA
+	}
+	return ""
+}
+
+var errUnsupportedKey = errors.New("keyring: unsupported key type")
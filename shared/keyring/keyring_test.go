@@ -0,0 +1,41 @@
+package keyring
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPruneNotifies checks that dropping an expired key runs the OnRotate callbacks,
+// so a middleware built with the old keys stops accepting tokens signed by it
+func TestPruneNotifies(t *testing.T) {
+	r, err := New(Config{Alg: EdDSA, RotateEvery: time.Hour, Overlap: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.VerificationKeys()); n != 2 {
+		t.Fatalf("%d keys right after a rotation, want the active and the retired one", n)
+	}
+
+	notified := 0
+	r.OnRotate(func() { notified++ })
+	if err := r.tick(); err != nil {
+		t.Fatal(err)
+	}
+	if notified != 0 {
+		t.Fatal("notified although no key expired")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := r.tick(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.VerificationKeys()); n != 1 {
+		t.Errorf("%d keys after the overlap window, want only the active one", n)
+	}
+	if notified != 1 {
+		t.Errorf("callbacks ran %d times after the retired key was dropped, want 1", notified)
+	}
+}
@@ -0,0 +1,266 @@
+// Package loginlimit protects login endpoints against password guessing.
+//
+// Every attempt takes a token from two buckets, one for the client IP and one for the account,
+// so neither one client trying many accounts nor many clients trying one account get far.
+// On top of that, an account is locked after MaxFailures failed logins in a row. Each lockout
+// lasts twice as long as the one before, up to LockoutMax, until a login succeeds.
+//
+// The state lives in a Store. MemoryStore keeps it in the process; a shared store (e.g. Redis)
+// makes the limits hold across several instances of a server.
+package loginlimit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+)
+
+// kinds of keys the limiter keeps state for
+const (
+	KindIP      = "ip"
+	KindAccount = "account"
+)
+
+// reasons an attempt is refused
+const (
+	ReasonIPLimited      = "ip_rate_limited"      // the client IP made too many attempts
+	ReasonAccountLimited = "account_rate_limited" // the account got too many attempts
+	ReasonAccountLocked  = "account_locked"       // the account is locked after failed logins
+)
+
+// Bucket is a token bucket: Burst attempts in a row, then one more every Refill
+type Bucket struct {
+	Burst  int
+	Refill time.Duration
+}
+
+// Config sets the limits
+type Config struct {
+	IP      Bucket
+	Account Bucket
+	// MaxFailures failed logins in a row lock the account
+	MaxFailures int
+	// Lockout is the first lockout, every further one doubles up to LockoutMax.
+	// LockoutMax is also how long failures and lockouts are remembered after the last one.
+	Lockout, LockoutMax time.Duration
+	// Store keeps the state, nil uses a new MemoryStore
+	Store Store
+}
+
+// Settings configure the limiter of a server, see FromConfig
+var Settings = []config.Setting{
+	{Name: "LOGIN_IP_BURST", Type: config.Int, Default: "20", Min: 1, Description: "login attempts one client IP can make in a row"},
+	{Name: "LOGIN_IP_REFILL", Type: config.Duration, Default: "3s", Description: "time until a client IP gets one more login attempt"},
+	{Name: "LOGIN_ACCOUNT_BURST", Type: config.Int, Default: "5", Min: 1, Description: "login attempts one account can get in a row"},
+	{Name: "LOGIN_ACCOUNT_REFILL", Type: config.Duration, Default: "30s", Description: "time until an account gets one more login attempt"},
+	{Name: "LOGIN_MAX_FAILURES", Type: config.Int, Default: "5", Min: 1, Description: "failed logins in a row that lock an account"},
+	{Name: "LOGIN_LOCKOUT", Type: config.Duration, Default: "1m", Description: "first lockout of an account, doubled for every further one"},
+	{Name: "LOGIN_LOCKOUT_MAX", Type: config.Duration, Default: "1h", Description: "longest lockout, also how long failed logins are remembered"},
+}
+
+// CheckSettings is the config.Rule of Settings
+func CheckSettings(cfg *config.Config) error {
+	if cfg.Duration("LOGIN_LOCKOUT") > cfg.Duration("LOGIN_LOCKOUT_MAX") {
+		return fmt.Errorf("LOGIN_LOCKOUT must not be longer than LOGIN_LOCKOUT_MAX")
+	}
+	return nil
+}
+
+// FromConfig creates a limiter from Settings, keeping its state in store (nil for memory)
+func FromConfig(cfg *config.Config, store Store) *Limiter {
+	return New(Config{
+		IP:          Bucket{Burst: cfg.Int("LOGIN_IP_BURST"), Refill: cfg.Duration("LOGIN_IP_REFILL")},
+		Account:     Bucket{Burst: cfg.Int("LOGIN_ACCOUNT_BURST"), Refill: cfg.Duration("LOGIN_ACCOUNT_REFILL")},
+		MaxFailures: cfg.Int("LOGIN_MAX_FAILURES"),
+		Lockout:     cfg.Duration("LOGIN_LOCKOUT"),
+		LockoutMax:  cfg.Duration("LOGIN_LOCKOUT_MAX"),
+		Store:       store,
+	})
+}
+
+// Limiter decides whether a login attempt may go ahead and records how it went
+type Limiter struct {
+	cfg   Config
+	store Store
+	now   func() time.Time
+}
+
+func New(cfg Config) *Limiter {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore()
+	}
+	return &Limiter{cfg: cfg, store: cfg.Store, now: time.Now}
+}
+
+// Decision is the answer to one attempt
+type Decision struct {
+	Allowed    bool
+	Reason     string        // why it was refused
+	RetryAfter time.Duration // when an attempt can succeed again
+}
+
+// Allow is called before the credentials are checked, so refused attempts don't even cost a password hash
+func (l *Limiter) Allow(ip, account string) Decision {
+	now := l.now()
+
+	var d Decision
+	l.store.Update(key(KindIP, ip), func(s *State) {
+		if wait := l.take(s, l.cfg.IP, now); wait > 0 {
+			d = Decision{Reason: ReasonIPLimited, RetryAfter: wait}
+		}
+	})
+	if d.Reason != "" {
+		return d
+	}
+
+	l.store.Update(key(KindAccount, account), func(s *State) {
+		if now.Before(s.LockedUntil) {
+			d = Decision{Reason: ReasonAccountLocked, RetryAfter: s.LockedUntil.Sub(now)}
+			return
+		}
+		if wait := l.take(s, l.cfg.Account, now); wait > 0 {
+			d = Decision{Reason: ReasonAccountLimited, RetryAfter: wait}
+		}
+	})
+	if d.Reason != "" {
+		return d
+	}
+	return Decision{Allowed: true}
+}
+
+// Failure records a failed login. It is counted against the name that was tried,
+// whether an account has that name or not, so a lockout doesn't tell which names exist.
+func (l *Limiter) Failure(account string) {
+	now := l.now()
+	l.store.Update(key(KindAccount, account), func(s *State) {
+		s.Failures++
+		s.LastFailure = now
+		if s.Failures >= l.cfg.MaxFailures {
+			s.LockedUntil = now.Add(l.lockout(s.Lockouts))
+			s.Lockouts++
+			s.Failures = 0
+		}
+		l.expire(s, l.cfg.Account)
+	})
+}
+
+// Success forgets the failures and lockouts of an account
+func (l *Limiter) Success(account string) {
+	l.store.Update(key(KindAccount, account), func(s *State) {
+		s.Failures, s.Lockouts = 0, 0
+		s.LastFailure, s.LockedUntil = time.Time{}, time.Time{}
+		l.expire(s, l.cfg.Account)
+	})
+}
+
+// lockout is the length of the lockout after n earlier ones: Lockout, 2*Lockout, 4*Lockout, ...
+func (l *Limiter) lockout(n int) time.Duration {
+	d := l.cfg.Lockout
+	for i := 0; i < n && d < l.cfg.LockoutMax; i++ {
+		d *= 2
+	}
+	return min(d, l.cfg.LockoutMax)
+}
+
+// take refills the bucket for the time that passed and takes one token.
+// It returns how long to wait when the bucket is empty.
+func (l *Limiter) take(s *State, b Bucket, now time.Time) time.Duration {
+	if s.Refilled.IsZero() {
+		s.Tokens = float64(b.Burst) // a key we haven't seen (or have forgotten) starts full
+	} else {
+		s.Tokens = min(float64(b.Burst), s.Tokens+float64(now.Sub(s.Refilled))/float64(b.Refill))
+	}
+	s.Refilled = now
+	defer l.expire(s, b)
+
+	if s.Tokens < 1 {
+		return time.Duration((1 - s.Tokens) * float64(b.Refill))
+	}
+	s.Tokens--
+	return 0
+}
+
+// expire sets when the state is no different from a fresh one: the bucket is full again
+// and the failures and lockouts are old enough to be forgotten
+func (l *Limiter) expire(s *State, b Bucket) {
+	expires := s.Refilled.Add(time.Duration((float64(b.Burst) - s.Tokens) * float64(b.Refill)))
+	if !s.LastFailure.IsZero() {
+		expires = later(expires, s.LastFailure.Add(l.cfg.LockoutMax))
+	}
+	if !s.LockedUntil.IsZero() {
+		expires = later(expires, s.LockedUntil.Add(l.cfg.LockoutMax))
+	}
+	s.Expires = expires
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Record is what an operator sees of one IP or account
+type Record struct {
+	Kind        string     `json:"kind"` // ip or account
+	ID          string     `json:"id"`
+	Attempts    int        `json:"attempts"` // attempts left right now
+	Failures    int        `json:"failures"` // failed logins since the last lockout or success
+	Lockouts    int        `json:"lockouts"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// Records lists the IPs and accounts that are locked, have failed logins or are out of attempts
+func (l *Limiter) Records() []Record {
+	now := l.now()
+	records := []Record{}
+	l.store.Range(func(k string, s State) {
+		if now.After(s.Expires) {
+			return // as good as forgotten
+		}
+		kind, id, _ := strings.Cut(k, ":")
+		b := l.cfg.IP
+		if kind == KindAccount {
+			b = l.cfg.Account
+		}
+		tokens := float64(b.Burst)
+		if !s.Refilled.IsZero() {
+			tokens = min(tokens, s.Tokens+float64(now.Sub(s.Refilled))/float64(b.Refill))
+		}
+		locked := now.Before(s.LockedUntil)
+		if !locked && s.Failures == 0 && tokens >= 1 {
+			return
+		}
+		r := Record{Kind: kind, ID: id, Attempts: int(tokens), Failures: s.Failures, Lockouts: s.Lockouts}
+		if locked {
+			until := s.LockedUntil
+			r.LockedUntil = &until
+		}
+		records = append(records, r)
+	})
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Kind != records[j].Kind {
+			return records[i].Kind < records[j].Kind
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+// Clear forgets everything about an IP or account: its attempts, failures and lockout.
+// It reports whether there was anything to forget.
+func (l *Limiter) Clear(kind, id string) bool {
+	return l.store.Delete(key(kind, id))
+}
+
+// key is the store key of an IP or account. Account names are compared case-insensitively,
+// so "Alice" and "alice" share their attempts.
+func key(kind, id string) string {
+	if kind == KindAccount {
+		id = strings.ToLower(strings.TrimSpace(id))
+	}
+	return kind + ":" + id
+}
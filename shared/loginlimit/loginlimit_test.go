@@ -0,0 +1,156 @@
+package loginlimit
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a fake time the tests move forward by hand.
+// It starts at the real time because MemoryStore drops states that expired by the wall clock.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(cfg Config) (*Limiter, *clock) {
+	c := &clock{t: time.Now()}
+	l := New(cfg)
+	l.now = c.now
+	return l, c
+}
+
+func TestBucket(t *testing.T) {
+	l, c := newTestLimiter(Config{
+		IP:          Bucket{Burst: 3, Refill: 10 * time.Second},
+		Account:     Bucket{Burst: 100, Refill: time.Second},
+		MaxFailures: 100, Lockout: time.Minute, LockoutMax: time.Hour,
+	})
+	steps := []struct {
+		wait time.Duration // before the attempt
+		want Decision
+	}{
+		{0, Decision{Allowed: true}}, // a full bucket: Burst attempts in a row
+		{0, Decision{Allowed: true}},
+		{0, Decision{Allowed: true}},
+		{0, Decision{Reason: ReasonIPLimited, RetryAfter: 10 * time.Second}},
+		{4 * time.Second, Decision{Reason: ReasonIPLimited, RetryAfter: 6 * time.Second}}, // refused attempts cost nothing
+		{6 * time.Second, Decision{Allowed: true}},                                        // one token refilled
+		{0, Decision{Reason: ReasonIPLimited, RetryAfter: 10 * time.Second}},
+		{time.Hour, Decision{Allowed: true}}, // refills up to Burst, not more
+		{0, Decision{Allowed: true}},
+		{0, Decision{Allowed: true}},
+		{0, Decision{Reason: ReasonIPLimited, RetryAfter: 10 * time.Second}},
+	}
+	for i, step := range steps {
+		c.advance(step.wait)
+		if got := l.Allow("10.0.0.1", "alice"); got != step.want {
+			t.Fatalf("attempt %d: got %+v, want %+v", i+1, got, step.want)
+		}
+	}
+	// every IP has its own bucket
+	if got := l.Allow("10.0.0.2", "alice"); !got.Allowed {
+		t.Errorf("another IP: %+v", got)
+	}
+}
+
+func TestAccountBucket(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		IP:          Bucket{Burst: 100, Refill: time.Second},
+		Account:     Bucket{Burst: 2, Refill: time.Minute},
+		MaxFailures: 100, Lockout: time.Minute, LockoutMax: time.Hour,
+	})
+	// many IPs trying one account share its bucket, whatever the case of the name
+	for i, name := range []string{"alice", " Alice", "ALICE"} {
+		got := l.Allow("10.0.0."+string(rune('1'+i)), name)
+		if want := i < 2; got.Allowed != want {
+			t.Fatalf("attempt %d: %+v", i+1, got)
+		}
+		if i == 2 && (got.Reason != ReasonAccountLimited || got.RetryAfter != time.Minute) {
+			t.Errorf("third attempt: %+v", got)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l, c := newTestLimiter(Config{
+		IP:          Bucket{Burst: 1000, Refill: time.Second},
+		Account:     Bucket{Burst: 1000, Refill: time.Second},
+		MaxFailures: 3, Lockout: time.Minute, LockoutMax: 5 * time.Minute,
+	})
+	fail := func(n int) {
+		for range n {
+			if d := l.Allow("10.0.0.1", "bob"); !d.Allowed {
+				t.Fatalf("attempt refused before the lockout: %+v", d)
+			}
+			l.Failure("bob")
+		}
+	}
+
+	// every lockout doubles: 1m, 2m, 4m, then capped at 5m
+	for _, lockout := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		fail(3)
+		d := l.Allow("10.0.0.1", "bob")
+		if d.Reason != ReasonAccountLocked || d.RetryAfter != lockout {
+			t.Fatalf("after 3 failures: %+v, want locked for %v", d, lockout)
+		}
+		c.advance(lockout - time.Second)
+		if d := l.Allow("10.0.0.1", "bob"); d.Reason != ReasonAccountLocked || d.RetryAfter != time.Second {
+			t.Fatalf("a second before the end: %+v", d)
+		}
+		c.advance(time.Second)
+	}
+
+	// a success forgets the lockouts, the next one is short again
+	l.Success("bob")
+	fail(2)
+	if d := l.Allow("10.0.0.1", "bob"); !d.Allowed {
+		t.Fatalf("locked after fewer than MaxFailures: %+v", d)
+	}
+	l.Failure("bob")
+	if d := l.Allow("10.0.0.1", "bob"); d.RetryAfter != time.Minute {
+		t.Errorf("lockout after a success: %+v, want 1m", d)
+	}
+
+	// failures are counted for names without an account too, and cleared by an operator
+	for range 3 {
+		l.Failure("nobody")
+	}
+	if d := l.Allow("10.0.0.1", "nobody"); d.Reason != ReasonAccountLocked {
+		t.Errorf("unknown name not locked: %+v", d)
+	}
+	if !l.Clear(KindAccount, "Nobody") {
+		t.Fatal("Clear found nothing")
+	}
+	if d := l.Allow("10.0.0.1", "nobody"); !d.Allowed {
+		t.Errorf("after Clear: %+v", d)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	l, c := newTestLimiter(Config{
+		IP:          Bucket{Burst: 1, Refill: time.Minute},
+		Account:     Bucket{Burst: 10, Refill: time.Second},
+		MaxFailures: 2, Lockout: time.Minute, LockoutMax: time.Hour,
+	})
+	l.Allow("10.0.0.1", "carol")
+	l.Failure("carol")
+	l.Failure("carol")
+	l.Allow("10.0.0.2", "dave")
+
+	records := l.Records()
+	if len(records) != 3 {
+		t.Fatalf("records %+v", records)
+	}
+	if r := records[0]; r.Kind != KindAccount || r.ID != "carol" || r.Lockouts != 1 || r.LockedUntil == nil || !r.LockedUntil.Equal(c.t.Add(time.Minute)) {
+		t.Errorf("locked account %+v", r)
+	}
+	if r := records[1]; r.Kind != KindIP || r.ID != "10.0.0.1" || r.Attempts != 0 {
+		t.Errorf("limited IP %+v", r)
+	}
+
+	// once the buckets are full and the failures are older than LockoutMax, nothing is left to show
+	c.advance(time.Hour + time.Minute)
+	if records := l.Records(); len(records) != 0 {
+		t.Errorf("records after everything expired: %+v", records)
+	}
+}
@@ -0,0 +1,87 @@
+package loginlimit
+
+import (
+	"sync"
+	"time"
+)
+
+// State is what the limiter remembers about one IP or account
+type State struct {
+	Tokens      float64   `json:"tokens"`
+	Refilled    time.Time `json:"refilled"` // when Tokens was last brought up to date
+	Failures    int       `json:"failures"` // failed logins in a row
+	LastFailure time.Time `json:"last_failure"`
+	Lockouts    int       `json:"lockouts"` // lockouts so far, the next one lasts longer
+	LockedUntil time.Time `json:"locked_until"`
+	// Expires is when the state is as good as new, a store may drop it after that
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps the limiter state. Implementations must be safe for concurrent use,
+// and Update must be atomic per key so two attempts can't take the same token.
+type Store interface {
+	// Update lets fn change the state of key; a key the store doesn't have starts from the zero State
+	Update(key string, fn func(state *State))
+	// Delete forgets key and reports whether it was there
+	Delete(key string) bool
+	// Range calls fn with every key and its state
+	Range(fn func(key string, state State))
+}
+
+// sweepEvery is how many updates MemoryStore handles between two sweeps of expired states
+const sweepEvery = 1024
+
+// MemoryStore keeps the state in a map of the process
+type MemoryStore struct {
+	mu      sync.Mutex
+	states  map[string]State
+	updates int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]State{}}
+}
+
+func (m *MemoryStore) Update(key string, fn func(state *State)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.states[key]
+	fn(&state)
+	now := time.Now()
+	if state.Expires.After(now) {
+		m.states[key] = state
+	} else {
+		delete(m.states, key) // as good as new, nothing worth remembering
+	}
+
+	// drop what expired, so trying random usernames can't make the map grow forever
+	if m.updates++; m.updates%sweepEvery == 0 {
+		for k, s := range m.states {
+			if !s.Expires.After(now) {
+				delete(m.states, k)
+			}
+		}
+	}
+}
+
+func (m *MemoryStore) Delete(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.states[key]
+	delete(m.states, key)
+	return ok
+}
+
+func (m *MemoryStore) Range(fn func(key string, state State)) {
+	m.mu.Lock()
+	states := make(map[string]State, len(m.states))
+	for k, s := range m.states {
+		states[k] = s
+	}
+	m.mu.Unlock()
+
+	for k, s := range states { // fn runs without the lock, it may call the store
+		fn(k, s)
+	}
+}
@@ -0,0 +1,97 @@
+// Package metrics exposes what a server is doing in the Prometheus text format on /metrics:
+// requests per route and status, their latency, the requests in flight and the state of the
+// database connection pools, next to the Go runtime and process metrics.
+//
+// Requests are labeled with the route pattern (/books/:id), never the path (/books/7),
+// so the number of series stays bounded whatever clients ask for.
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/reqlog"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the collectors of one app. Each app gets its own registry, so tests can
+// build as many apps as they like without registering a metric twice.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests answered, by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time to answer an HTTP request, by method and route pattern.",
+			Buckets: prometheus.DefBuckets, // 5ms to 10s
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests being answered right now.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.latency, m.inFlight,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Registerer is where a server registers metrics of its own, e.g. the GORM query histogram
+func (m *Metrics) Registerer() prometheus.Registerer {
+	return m.registry
+}
+
+// RegisterDB exports the pool statistics of db (db.Stats()) as go_sql_* metrics labeled db_name=name:
+// open, in use and idle connections, and how often and how long requests waited for one
+func (m *Metrics) RegisterDB(name string, db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Middleware counts and times every request. Register it before the other handlers,
+// after reqlog.Middleware if both are used.
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		if err := c.Next(); err != nil {
+			// answer the error now, so the status counted is the one the client gets
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		// "" when no route answered: an unknown path, or a middleware such as the token check said no
+		route := reqlog.Route(c)
+		// Fiber reuses the request buffers, a label kept by Prometheus must be a copy
+		method := utils.CopyString(c.Method())
+		status := strconv.Itoa(c.Response().StatusCode())
+		m.requests.WithLabelValues(method, route, status).Inc()
+		m.latency.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format, for GET /metrics
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,210 @@
+// Package reqlog logs one structured line per request with log/slog, tagged with a request ID.
+//
+// The ID comes from the X-Request-ID header when the client (or a proxy in front) sent a usable one,
+// otherwise a new one is made up. It is echoed in the response and carried in the request's context,
+// so every log record written with that context, database queries included, names the request it belongs to.
+package reqlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/RookieJoel/GoAPI-essential/shared/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderRequestID carries the request ID in both directions
+const HeaderRequestID = "X-Request-ID"
+
+// SlowQuery is how long a database query may take before it is logged as a warning
+const SlowQuery = 200 * time.Millisecond
+
+// Settings configure the logger of a server, see Setup
+var Settings = []config.Setting{
+	{Name: "LOG_LEVEL", Default: "info", Options: []string{"debug", "info", "warn", "error"}, Description: "lowest level logged, debug includes every database query"},
+	{Name: "LOG_FORMAT", Default: "json", Options: []string{"json", "text"}, Description: "json for log collectors, text for reading in a terminal"},
+}
+
+// Setup makes the logger described by Settings the default one, writing to stdout.
+// The standard log package writes through it as well, so the whole server logs in one format.
+func Setup(cfg *config.Config) *slog.Logger {
+	logger := New(os.Stdout, cfg.Get("LOG_FORMAT"), cfg.Get("LOG_LEVEL"))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// New creates a logger that adds the request ID of the context to every record
+func New(w io.Writer, format, level string) *slog.Logger {
+	var lvl slog.Level
+	_ = lvl.UnmarshalText([]byte(level)) // an unknown level stays info
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler = slog.NewJSONHandler(w, opts)
+	if format == "text" {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+// contextHandler adds the request ID found in the context of a record
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := ID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type idKey struct{}
+
+// WithID returns a context carrying the request ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ID returns the request ID of the context, "" outside a request
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Subject names the authenticated caller of a request, "" when there is none.
+// It runs after the handlers, so it can read what an auth middleware left in c.Locals.
+type Subject func(c *fiber.Ctx) string
+
+// Middleware assigns the request ID and logs the request once it is answered.
+// Register it before every other handler, so it also sees the requests they reject.
+func Middleware(logger *slog.Logger, subject Subject) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		id := c.Get(HeaderRequestID)
+		if !validID(id) {
+			id = newID()
+		}
+		c.Set(HeaderRequestID, id)
+		c.SetUserContext(WithID(c.UserContext(), id)) // handlers pass c.UserContext() on to the database
+
+		if err := c.Next(); err != nil {
+			// answer the error now, so the line below logs the status the client gets
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		status := c.Response().StatusCode()
+		level := slog.LevelInfo
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", c.Method()),
+			slog.String("route", Route(c)),
+			slog.String("path", c.Path()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("ip", c.IP()),
+		}
+		if subject != nil {
+			if sub := subject(c); sub != "" {
+				attrs = append(attrs, slog.String("subject", sub))
+			}
+		}
+		// the ID is already among the attributes, so the background context keeps it from being added twice
+		logger.LogAttrs(context.Background(), level, "request", attrs...)
+		return nil
+	}
+}
+
+// Route is the pattern of the route that answered, e.g. /books/:id, so all requests for
+// one route share a value. It is "" when no route matched or a middleware answered on its own.
+func Route(c *fiber.Ctx) string {
+	route := c.Route()
+	if !endpointsOf(c.App())[route.Method+" "+route.Path] {
+		return "" // c.Route() is then the middleware that ran last, e.g. "/" for app.Use
+	}
+	return route.Path
+}
+
+// endpoints caches the routes of each app without its middleware, c.Route() can't tell them apart
+var endpoints sync.Map // *fiber.App → map[string]bool
+
+func endpointsOf(app *fiber.App) map[string]bool {
+	if set, ok := endpoints.Load(app); ok {
+		return set.(map[string]bool)
+	}
+	set := map[string]bool{}
+	for _, r := range app.GetRoutes(true) { // true leaves out app.Use and group middleware
+		set[r.Method+" "+r.Path] = true
+	}
+	// routes are all registered before the first request, so the set never goes stale
+	actual, _ := endpoints.LoadOrStore(app, set)
+	return actual.(map[string]bool)
+}
+
+// Query logs a database statement run for the request in ctx: at debug level, as a warning
+// when it took longer than SlowQuery and as an error when it failed. rows < 0 means unknown.
+func Query(ctx context.Context, query string, took time.Duration, rows int64, err error) {
+	level := slog.LevelDebug
+	switch {
+	case err != nil:
+		level = slog.LevelError
+	case took > SlowQuery:
+		level = slog.LevelWarn
+	}
+	logger := slog.Default()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("sql", query),
+		slog.Float64("took_ms", float64(took.Microseconds())/1000),
+	}
+	if rows >= 0 {
+		attrs = append(attrs, slog.Int64("rows", rows))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	logger.LogAttrs(ctx, level, "query", attrs...)
+}
+
+// validID accepts what a proxy or client is likely to send (UUIDs, hex, base64) and nothing that
+// could forge log lines or bloat them
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':', r == '+', r == '/', r == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return hex.EncodeToString(buf)
+}